@@ -0,0 +1,274 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+)
+
+// ErrNotConnected is returned by Disconnect if there is no VPN connection to undo
+var ErrNotConnected = errors.New("Not connected to any VPN")
+
+// Endpoint is everything a connector needs to know to connect to a VPN server
+type Endpoint struct {
+	Protocol string // "pptp" or "wireguard"
+	IP       string
+	Port     int
+	Username string
+	Password string
+	Config   string // protocol specific client configuration, like a wg-quick config file
+	Routing  Routing
+}
+
+// Connector establishes a VPN connection through one particular client implementation
+type Connector interface {
+	Name() string
+	Protocols() []string
+	Available() bool // can it be used on this machine
+	Connect(endpoint Endpoint, session *Session) error
+	Disconnect(session *Session) error
+}
+
+// Session is the current VPN connection of this machine,
+// it is remembered in the state directory so that disconnect can undo everything connect did
+type Session struct {
+	Connector  string    `json:"connector"`
+	Protocol   string    `json:"protocol"`
+	Server     string    `json:"server"`
+	Port       int       `json:"port,omitempty"`
+	Interface  string    `json:"interface,omitempty"`
+	Routes     []string  `json:"routes,omitempty"` // default routes before connecting, as listed by "ip route show default"
+	Gateway    string    `json:"gateway,omitempty"`
+	ResolvConf string    `json:"resolv_conf,omitempty"`
+	Included   []string  `json:"included,omitempty"`    // networks routed through the VPN, everything if empty
+	Excluded   []string  `json:"excluded,omitempty"`    // networks routed past the VPN
+	KillSwitch string    `json:"kill_switch,omitempty"` // firewall which installed the kill switch rules
+	DNS        bool      `json:"dns,omitempty"`         // the connector switched DNS to the nameservers of the VPN
+	Started    time.Time `json:"started"`
+}
+
+const sessionFile = "connection.json"
+
+var connectors = []Connector{
+	pptpsetup{},
+	nmcli{},
+	wgQuick{},
+}
+
+// run executes a command and returns its output, it is a variable so tests can record commands instead
+var run = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("Could not run %s: %v\n%s", name, err, out)
+	}
+	return string(out), nil
+}
+
+// Get returns the connector of the given name for a protocol, falling back to the configured vpn_connector.
+// Without any it is the "custom" one if autoconnect_cmd is configured, otherwise the first one available on this machine.
+func Get(name string, protocol string, options config.Options, out io.Writer) (Connector, error) {
+	if len(name) == 0 {
+		name = options.Connector
+	}
+	if name == "custom" || (len(name) == 0 && len(options.ConnectCmd) > 0) {
+		return custom{ConnectCmd: options.ConnectCmd, DisconnectCmd: options.DisconnectCmd, Out: out}, nil
+	}
+
+	for _, connector := range connectors {
+		if len(name) > 0 && connector.Name() != name {
+			continue
+		}
+		if !supports(connector, protocol) {
+			if len(name) > 0 {
+				return nil, fmt.Errorf("Connector [%s] does not support protocol [%s]", name, protocol)
+			}
+			continue
+		}
+		if !connector.Available() {
+			if len(name) > 0 {
+				return nil, fmt.Errorf("Connector [%s] is not available on this machine", name)
+			}
+			continue
+		}
+		return connector, nil
+	}
+	if len(name) > 0 {
+		return nil, fmt.Errorf("Unknown connector: %s", name)
+	}
+	return nil, fmt.Errorf("No connector available for protocol [%s] on %s", protocol, runtime.GOOS)
+}
+
+func supports(connector Connector, protocol string) bool {
+	for _, p := range connector.Protocols() {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// Connect establishes a VPN connection and remembers it in the state directory
+func Connect(connector Connector, endpoint Endpoint, stateDir string) (*Session, error) {
+	current, err := Current(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("Already connected to [%s], disconnect first", current.Server)
+	}
+
+	session := &Session{
+		Connector: connector.Name(),
+		Protocol:  endpoint.Protocol,
+		Server:    endpoint.IP,
+		Port:      endpoint.Port,
+		Started:   time.Now().UTC(),
+	}
+	if err := saveNetwork(session); err != nil {
+		return nil, err
+	}
+	if err := connector.Connect(endpoint, session); err != nil {
+		// the connection might be halfway up, like a pptpsetup profile with pppd running
+		connector.Disconnect(session)
+		restoreNetwork(session)
+		return nil, err
+	}
+	if err := applyRouting(session, endpoint.Routing); err != nil {
+		connector.Disconnect(session)
+		restoreNetwork(session)
+		return nil, err
+	}
+
+	if err := save(stateDir, session); err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// Disconnect undoes the current VPN connection, restoring the routes and DNS configuration from before
+func Disconnect(stateDir string, options config.Options, out io.Writer) (*Session, error) {
+	session, err := Current(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrNotConnected
+	}
+
+	connector, err := Get(session.Connector, session.Protocol, options, out)
+	if err != nil {
+		return session, err
+	}
+	// restore the network even if the connector failed, the connection might already be gone
+	disconnectErr := connector.Disconnect(session)
+	if err := restoreNetwork(session); err != nil {
+		return session, err
+	}
+	// only now that the routes are back, otherwise traffic could leak past the tunnel while it is torn down
+	if err := disableKillSwitch(session); err != nil {
+		return session, err
+	}
+	// networking is back to normal, so the session is over even if the connector failed,
+	// e.g. because the connection was already gone
+	if disconnectErr != nil && out != nil {
+		fmt.Fprintf(out, "Warning: %v\n", disconnectErr)
+	}
+	return session, remove(stateDir)
+}
+
+// Recover restores networking after easy-vpn died while connected: it removes any kill switch rules,
+// tears down the VPN connection if it is still there, and restores the routes and DNS configuration from before
+func Recover(stateDir string, options config.Options, out io.Writer) (*Session, error) {
+	session, err := Current(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rules are removed even without a session, it might have been lost while the kill switch was enabled
+	for _, fw := range firewalls {
+		if fw.Available() {
+			if err := fw.Disable(); err != nil {
+				return session, fmt.Errorf("Could not disable kill switch\n%v", err)
+			}
+		}
+	}
+	if session == nil {
+		return nil, nil
+	}
+
+	if connector, err := Get(session.Connector, session.Protocol, options, out); err == nil {
+		connector.Disconnect(session) // probably already gone
+	}
+	if err := restoreNetwork(session); err != nil {
+		return session, err
+	}
+	return session, remove(stateDir)
+}
+
+// Current returns the current VPN connection, or nil if there is none
+func Current(stateDir string) (*Session, error) {
+	filename, err := sessionPath(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file: %s\n%v", filename, err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("Could not parse file: %s\n%v", filename, err)
+	}
+	return &session, nil
+}
+
+func save(stateDir string, session *Session) error {
+	filename, err := sessionPath(stateDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Could not create state directory: %s\n%v", filepath.Dir(filename), err)
+	}
+
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("Could not write file: %s\n%v", filename, err)
+	}
+	return nil
+}
+
+func remove(stateDir string) error {
+	filename, err := sessionPath(stateDir)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove file: %s\n%v", filename, err)
+	}
+	return nil
+}
+
+func sessionPath(stateDir string) (string, error) {
+	dir, err := config.ExpandHome(stateDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sessionFile), nil
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// record replaces run for the duration of a test, answering "ip route show default" with the given routes
+func record(t *testing.T, routes string) *[]string {
+	var commands []string
+	original := run
+	run = func(name string, args ...string) (string, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		commands = append(commands, command)
+		if command == "ip route show default" {
+			return routes, nil
+		}
+		return "", nil
+	}
+	t.Cleanup(func() { run = original })
+	return &commands
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func Test_Client_Get(t *testing.T) {
+	connector, err := Get("", "pptp", config.Options{ConnectCmd: [][]string{{"connect"}}}, nil)
+	assert.Nil(t, err)
+	if assert.NotNil(t, connector) {
+		assert.Equal(t, "custom", connector.Name())
+	}
+
+	connector, err = Get("", "pptp", config.Options{Connector: "custom"}, nil)
+	assert.Nil(t, err)
+	if assert.NotNil(t, connector) {
+		assert.Equal(t, "custom", connector.Name())
+	}
+
+	_, err = Get("nmcli", "wireguard", config.Options{}, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Connector [nmcli] does not support protocol [wireguard]", err.Error())
+	}
+
+	_, err = Get("openvpn", "pptp", config.Options{}, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Unknown connector: openvpn", err.Error())
+	}
+}
+
+func Test_Client_ConnectDisconnect(t *testing.T) {
+	dir := tempDir(t)
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	defer func() { resolvConf = "/etc/resolv.conf" }()
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("nameserver 192.168.1.1\n"), 0644))
+
+	commands := record(t, "default via 192.168.1.1 dev wlan0 proto dhcp metric 600\n")
+	options := config.Options{
+		ConnectCmd:    [][]string{{"connect", "$IP", "$USER", "$PASS"}},
+		DisconnectCmd: [][]string{{"disconnect", "$IP"}},
+	}
+	connector, _ := Get("", "pptp", options, nil)
+
+	session, err := Connect(connector, Endpoint{Protocol: "pptp", IP: "10.0.0.1", Username: "jdoe", Password: "s3cr3t"}, dir)
+	assert.Nil(t, err)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, "custom", session.Connector)
+		assert.Equal(t, "10.0.0.1", session.Server)
+		assert.Equal(t, "nameserver 192.168.1.1\n", session.ResolvConf)
+	}
+	assert.Contains(t, *commands, "connect 10.0.0.1 jdoe s3cr3t")
+
+	current, err := Current(dir)
+	assert.Nil(t, err)
+	if assert.NotNil(t, current) {
+		assert.Equal(t, "10.0.0.1", current.Server)
+	}
+
+	_, err = Connect(connector, Endpoint{Protocol: "pptp", IP: "10.0.0.2"}, dir)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Already connected to [10.0.0.1], disconnect first", err.Error())
+	}
+
+	// the connection messed with DNS
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("nameserver 10.0.0.1\n"), 0644))
+
+	var out bytes.Buffer
+	session, err = Disconnect(dir, options, &out)
+	assert.Nil(t, err)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, "10.0.0.1", session.Server)
+	}
+	assert.Contains(t, *commands, "disconnect 10.0.0.1")
+
+	data, _ := ioutil.ReadFile(resolvConf)
+	assert.Equal(t, "nameserver 192.168.1.1\n", string(data))
+
+	current, err = Current(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, current)
+
+	_, err = Disconnect(dir, options, &out)
+	assert.Equal(t, ErrNotConnected, err)
+}
+
+func Test_Client_RouteThrough(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("routes are only handled on linux")
+	}
+
+	commands := record(t, "default via 192.168.1.1 dev wlan0 proto dhcp metric 600\n")
+	session := &Session{Server: "10.0.0.1"}
+	assert.Nil(t, saveNetwork(session))
+	assert.Equal(t, "192.168.1.1", session.Gateway)
+
+	assert.Nil(t, routeThrough(session, "ppp0"))
+	assert.Equal(t, []string{
+		"ip route show default",
+		"ip route replace 10.0.0.1/32 via 192.168.1.1",
+		"ip route replace default dev ppp0",
+	}, *commands)
+
+	*commands = nil
+	run = func(name string, args ...string) (string, error) {
+		*commands = append(*commands, strings.Join(append([]string{name}, args...), " "))
+		return "default dev ppp0 scope link\n", nil
+	}
+	assert.Nil(t, restoreNetwork(session))
+	assert.Equal(t, []string{
+		"ip route del 10.0.0.1/32 via 192.168.1.1",
+		"ip route show default",
+		"ip route del default dev ppp0 scope link",
+		"ip route replace default via 192.168.1.1 dev wlan0 proto dhcp metric 600",
+	}, *commands)
+}
+
+func Test_Client_ParseDefaultRoutes(t *testing.T) {
+	routes, gateway := parseDefaultRoutes(`default via 192.168.1.1 dev wlan0 proto dhcp metric 600
+default via 10.1.1.1 dev eth0 metric 100
+`)
+	assert.Equal(t, []string{
+		"default via 192.168.1.1 dev wlan0 proto dhcp metric 600",
+		"default via 10.1.1.1 dev eth0 metric 100",
+	}, routes)
+	assert.Equal(t, "192.168.1.1", gateway)
+
+	routes, gateway = parseDefaultRoutes("")
+	assert.Nil(t, routes)
+	assert.Equal(t, "", gateway)
+}
+
+func Test_Client_Custom(t *testing.T) {
+	var out bytes.Buffer
+	c := custom{ConnectCmd: [][]string{{"echo", "hello world!"}}, Out: &out}
+	err := c.Connect(Endpoint{IP: "123.456.789", Username: "testuser", Password: "testpassword"}, &Session{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "hello world!\n\n", out.String())
+	}
+
+	c = custom{ConnectCmd: [][]string{{"false"}}}
+	err = c.Connect(Endpoint{}, &Session{})
+	assert.NotNil(t, err)
+}
+
+func Test_Client_ReplaceCommandVariables(t *testing.T) {
+	result := replaceCommandVariables(
+		[][]string{[]string{"connect", ";$IP;", ":$USER:", " $PASS "}, []string{"disconnect"}},
+		"123.456.789",
+		"testuser",
+		"testpassword")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 2, len(result))
+		assert.Equal(t, "connect", result[0][0])
+		assert.Equal(t, ";123.456.789;", result[0][1])
+		assert.Equal(t, ":testuser:", result[0][2])
+		assert.Equal(t, " testpassword ", result[0][3])
+		assert.Equal(t, "disconnect", result[1][0])
+	}
+}
+
+func Test_Client_UsePeerDNS(t *testing.T) {
+	dir := tempDir(t)
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	defer func() { resolvConf = "/etc/resolv.conf" }()
+	pppResolvConf = filepath.Join(dir, "ppp-resolv.conf")
+	defer func() { pppResolvConf = "/etc/ppp/resolv.conf" }()
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("search example.com\nnameserver 192.168.1.1\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(pppResolvConf, []byte("nameserver 8.8.8.8\nnameserver 8.8.4.4\n"), 0644))
+
+	record(t, "")
+	session := &Session{Server: "10.0.0.1"}
+	assert.Nil(t, saveNetwork(session))
+	assert.Nil(t, usePeerDNS(session))
+	assert.True(t, session.DNS)
+	data, _ := ioutil.ReadFile(resolvConf)
+	assert.Equal(t, "# written by easy-vpn, restored on disconnect\nsearch example.com\nnameserver 8.8.8.8\nnameserver 8.8.4.4\n", string(data))
+
+	assert.Nil(t, restoreNetwork(session))
+	data, _ = ioutil.ReadFile(resolvConf)
+	assert.Equal(t, "search example.com\nnameserver 192.168.1.1\n", string(data))
+}
+
+func Test_Client_HasDNS(t *testing.T) {
+	assert.True(t, hasDNS("[Interface]\nAddress = 10.8.0.2/32\nDNS = 10.8.0.1\n"))
+	assert.False(t, hasDNS("[Interface]\nAddress = 10.8.0.2/32\n"))
+}
+
+func Test_Client_ConnectDisconnect_Failing(t *testing.T) {
+	dir := tempDir(t)
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	defer func() { resolvConf = "/etc/resolv.conf" }()
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("nameserver 192.168.1.1\n"), 0644))
+
+	var commands []string
+	failing := "connect"
+	original := run
+	run = func(name string, args ...string) (string, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		if name == failing {
+			return "", errors.New(name + " failed")
+		}
+		return "", nil
+	}
+	defer func() { run = original }()
+
+	options := config.Options{ConnectCmd: [][]string{{"connect", "$IP"}}, DisconnectCmd: [][]string{{"disconnect", "$IP"}}}
+	connector, _ := Get("", "pptp", options, nil)
+
+	// a connection failing halfway is torn down again
+	_, err := Connect(connector, Endpoint{Protocol: "pptp", IP: "10.0.0.1"}, dir)
+	assert.NotNil(t, err)
+	assert.Contains(t, commands, "disconnect 10.0.0.1")
+	current, _ := Current(dir)
+	assert.Nil(t, current)
+
+	// the session is over once networking is restored, even if the connection was already gone
+	failing = "disconnect"
+	_, err = Connect(connector, Endpoint{Protocol: "pptp", IP: "10.0.0.1"}, dir)
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	_, err = Disconnect(dir, options, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, "Warning: disconnect failed\n", out.String())
+	current, _ = Current(dir)
+	assert.Nil(t, current)
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// name of the VPN connection within the connectors
+const profile = "easy-vpn"
+
+func installed(binaries ...string) bool {
+	for _, binary := range binaries {
+		if _, err := exec.LookPath(binary); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// pptpsetup connects through pppd on linux, routes all traffic through the ppp interface
+// and switches DNS to the nameservers pushed by the VPN server
+type pptpsetup struct{}
+
+// pppPeer and pppResolvConf are variables so tests can use files of their own,
+// pppd writes the nameservers pushed by the server to pppResolvConf if the peer asks for "usepeerdns"
+var (
+	pppPeer       = "/etc/ppp/peers/" + profile
+	pppResolvConf = "/etc/ppp/resolv.conf"
+)
+
+func (pptpsetup) Name() string {
+	return "pptpsetup"
+}
+
+func (pptpsetup) Protocols() []string {
+	return []string{"pptp"}
+}
+
+func (pptpsetup) Available() bool {
+	return runtime.GOOS == "linux" && installed("pptpsetup", "pppd", "ip")
+}
+
+func (pptpsetup) Connect(endpoint Endpoint, session *Session) error {
+	before, err := pppInterfaces()
+	if err != nil {
+		return err
+	}
+
+	if _, err := run("pptpsetup", "--create", profile, "--server", endpoint.IP,
+		"--username", endpoint.Username, "--password", endpoint.Password, "--encrypt"); err != nil {
+		return err
+	}
+	peer, err := os.OpenFile(pppPeer, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open file: %s\n%v", pppPeer, err)
+	}
+	_, err = peer.WriteString("usepeerdns\n")
+	peer.Close()
+	if err != nil {
+		return fmt.Errorf("Could not write file: %s\n%v", pppPeer, err)
+	}
+	os.Remove(pppResolvConf) // leftover of a previous connection
+	if _, err := run("pppd", "call", profile); err != nil {
+		return err
+	}
+
+	// wait for the new ppp interface to come up
+	for i := 0; i < 30; i++ {
+		after, err := pppInterfaces()
+		if err != nil {
+			return err
+		}
+		for _, iface := range after {
+			if !contains(before, iface) {
+				if err := routeThrough(session, iface); err != nil {
+					return err
+				}
+				return usePeerDNS(session)
+			}
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return fmt.Errorf("pppd did not bring up a ppp interface")
+}
+
+// usePeerDNS replaces the nameservers of resolv.conf with those pushed by the VPN server,
+// restoreNetwork puts back the resolv.conf from before on disconnect
+func usePeerDNS(session *Session) error {
+	var nameservers []string
+	for i := 0; i < 10 && len(nameservers) == 0; i++ {
+		if data, err := ioutil.ReadFile(pppResolvConf); err == nil {
+			nameservers = parseNameservers(string(data))
+		}
+		if len(nameservers) == 0 {
+			time.Sleep(1 * time.Second)
+		}
+	}
+	if len(nameservers) == 0 {
+		return fmt.Errorf("VPN server did not push any nameservers, DNS would not go through the VPN")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# written by easy-vpn, restored on disconnect\n")
+	for _, line := range strings.Split(session.ResolvConf, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && (fields[0] == "search" || fields[0] == "options") {
+			fmt.Fprintln(&buf, line)
+		}
+	}
+	for _, nameserver := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", nameserver)
+	}
+	if err := ioutil.WriteFile(resolvConf, []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("Could not write DNS configuration: %s\n%v", resolvConf, err)
+	}
+	session.DNS = true
+	return nil
+}
+
+func (pptpsetup) Disconnect(session *Session) error {
+	if _, err := run("poff", profile); err != nil {
+		run("pkill", "-f", "pppd call "+profile)
+	}
+	_, err := run("pptpsetup", "--delete", profile)
+	return err
+}
+
+func pppInterfaces() (ifaces []string, err error) {
+	out, err := run("ip", "-o", "link", "show")
+	if err != nil {
+		return nil, err
+	}
+	// lines look like "5: ppp0: <POINTOPOINT,MULTICAST,NOARP,UP,LOWER_UP> mtu 1496 ..."
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && strings.HasPrefix(fields[1], "ppp") {
+			ifaces = append(ifaces, strings.TrimSuffix(fields[1], ":"))
+		}
+	}
+	return ifaces, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// nmcli connects through NetworkManager, which takes care of routes and DNS itself
+type nmcli struct{}
+
+func (nmcli) Name() string {
+	return "nmcli"
+}
+
+func (nmcli) Protocols() []string {
+	return []string{"pptp"}
+}
+
+func (nmcli) Available() bool {
+	return installed("nmcli")
+}
+
+func (nmcli) Connect(endpoint Endpoint, session *Session) error {
+	session.DNS = true
+	run("nmcli", "connection", "delete", profile) // leftover of a previous connection
+	args := []string{"connection", "add", "type", "vpn", "con-name", profile, "ifname", "*", "vpn-type", "pptp",
+		"vpn.data", fmt.Sprintf("gateway=%s,user=%s,require-mppe=yes,password-flags=0", endpoint.IP, endpoint.Username),
+		"vpn.secrets", "password=" + endpoint.Password}
+	if endpoint.Routing.Split() {
+		// keep NetworkManager from fighting over the default route with applyRouting
+		args = append(args, "ipv4.never-default", "yes")
+	}
+	if _, err := run("nmcli", args...); err != nil {
+		return err
+	}
+	_, err := run("nmcli", "connection", "up", profile)
+	return err
+}
+
+func (nmcli) Disconnect(session *Session) error {
+	run("nmcli", "connection", "down", profile)
+	_, err := run("nmcli", "connection", "delete", profile)
+	return err
+}
+
+// wgQuick connects to wireguard servers, wg-quick takes care of routes and DNS itself, along the AllowedIPs
+type wgQuick struct{}
+
+// wgConfig is a variable so tests can use a file of their own
+var wgConfig = "/etc/wireguard/" + profile + ".conf"
+
+func (wgQuick) Name() string {
+	return "wg-quick"
+}
+
+func (wgQuick) Protocols() []string {
+	return []string{"wireguard"}
+}
+
+func (wgQuick) Available() bool {
+	return installed("wg-quick")
+}
+
+func (wgQuick) Connect(endpoint Endpoint, session *Session) error {
+	if len(endpoint.Config) == 0 {
+		return fmt.Errorf("No wireguard configuration for [%s]", endpoint.IP)
+	}
+	config := endpoint.Config
+	if endpoint.Routing.Split() {
+		config = allowedIPs(config, endpoint.Routing.Include)
+	}
+	if err := ioutil.WriteFile(wgConfig, []byte(config), 0600); err != nil {
+		return fmt.Errorf("Could not write file: %s\n%v", wgConfig, err)
+	}
+	if _, err := run("wg-quick", "up", wgConfig); err != nil {
+		return err
+	}
+	session.DNS = hasDNS(config)
+	return nil
+}
+
+// hasDNS tells whether a wireguard configuration has wg-quick switch DNS, by a "DNS = <ip>" line
+func hasDNS(config string) bool {
+	for _, line := range strings.Split(config, "\n") {
+		if key := strings.SplitN(line, "=", 2); len(key) == 2 && strings.EqualFold(strings.TrimSpace(key[0]), "DNS") {
+			return true
+		}
+	}
+	return false
+}
+
+func (wgQuick) Disconnect(session *Session) error {
+	_, err := run("wg-quick", "down", wgConfig)
+	os.Remove(wgConfig)
+	return err
+}
+
+// custom runs the configured autoconnect_cmd and autodisconnect_cmd,
+// which understand these 3 variables: $IP, $USER, $PASS
+type custom struct {
+	ConnectCmd    [][]string
+	DisconnectCmd [][]string
+	Out           io.Writer
+}
+
+func (custom) Name() string {
+	return "custom"
+}
+
+func (custom) Protocols() []string {
+	return []string{"pptp", "wireguard"}
+}
+
+func (custom) Available() bool {
+	return true
+}
+
+func (c custom) Connect(endpoint Endpoint, session *Session) error {
+	return c.run(replaceCommandVariables(c.ConnectCmd, endpoint.IP, endpoint.Username, endpoint.Password))
+}
+
+func (c custom) Disconnect(session *Session) error {
+	return c.run(replaceCommandVariables(c.DisconnectCmd, session.Server, "", ""))
+}
+
+func (c custom) run(commands [][]string) error {
+	for _, command := range commands {
+		if len(command) == 0 {
+			continue
+		}
+		output, err := run(command[0], command[1:]...)
+		if err != nil {
+			return err
+		}
+		if c.Out != nil {
+			fmt.Fprintln(c.Out, output)
+		}
+	}
+	return nil
+}
+
+func replaceCommandVariables(commands [][]string, ip, username, password string) [][]string {
+	result := make([][]string, len(commands))
+	for i, command := range commands {
+		cmd := make([]string, len(command))
+		for j, arg := range command {
+			arg = strings.Replace(arg, "$IP", ip, -1)
+			arg = strings.Replace(arg, "$USER", username, -1)
+			arg = strings.Replace(arg, "$PASS", password, -1)
+			cmd[j] = arg
+		}
+		result[i] = cmd
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+)
+
+// dial is a variable so tests can pretend to reach servers
+var dial = net.DialTimeout
+
+// Healthy checks that the VPN interface is still up and routing, and that the VPN server is still reachable
+func Healthy(session *Session) error {
+	if runtime.GOOS == "linux" {
+		if len(session.Interface) > 0 {
+			out, err := run("ip", "link", "show", "dev", session.Interface)
+			if err != nil {
+				return fmt.Errorf("VPN interface [%s] is gone", session.Interface)
+			}
+			if strings.Contains(out, "state DOWN") {
+				return fmt.Errorf("VPN interface [%s] is down", session.Interface)
+			}
+		} else if len(session.Included) == 0 {
+			if _, err := tunnelInterface(session); err != nil {
+				return err
+			}
+		}
+	}
+
+	if session.Port > 0 {
+		address := net.JoinHostPort(session.Server, strconv.Itoa(session.Port))
+		conn, err := dial("tcp", address, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("VPN server [%s] is not reachable\n%v", address, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// Reconnect runs the connector again, after tearing down what is left of the current connection.
+// Any kill switch stays in place meanwhile, so no traffic leaks while the VPN is down,
+// which is why the VPN server has to stay the same.
+func Reconnect(connector Connector, endpoint Endpoint, stateDir string, options config.Options, out io.Writer) (*Session, error) {
+	previous, err := Current(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.Server != endpoint.IP {
+			return nil, fmt.Errorf("Can not reconnect to [%s], connected to [%s]", endpoint.IP, previous.Server)
+		}
+		if c, err := Get(previous.Connector, previous.Protocol, options, out); err == nil {
+			c.Disconnect(previous) // probably already gone
+		}
+		restoreNetwork(previous)
+		if err := remove(stateDir); err != nil {
+			return nil, err
+		}
+	}
+
+	session, err := Connect(connector, endpoint, stateDir)
+	if err != nil {
+		if previous != nil && len(previous.KillSwitch) > 0 {
+			// keep the kill switch recoverable
+			save(stateDir, previous)
+		}
+		return nil, err
+	}
+	if previous != nil && len(previous.KillSwitch) > 0 {
+		// the new interface might be called differently
+		if err := disableKillSwitch(previous); err != nil {
+			return session, err
+		}
+		if err := EnableKillSwitch(session, stateDir); err != nil {
+			return session, err
+		}
+	}
+	return session, nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Client_Healthy(t *testing.T) {
+	original := dial
+	defer func() { dial = original }()
+	var dialed []string
+	dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if strings.HasPrefix(address, "198.51.100.1:") {
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	commands := record(t, "")
+	assert.Nil(t, Healthy(&Session{Server: "198.51.100.1", Port: 1723, Interface: "ppp0"}))
+	assert.Equal(t, []string{"198.51.100.1:1723"}, dialed)
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, []string{"ip link show dev ppp0"}, *commands)
+	}
+
+	err := Healthy(&Session{Server: "198.51.100.2", Port: 1723, Interface: "ppp0"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "VPN server [198.51.100.2:1723] is not reachable\nconnection refused", err.Error())
+	}
+
+	if runtime.GOOS == "linux" {
+		run = func(name string, args ...string) (string, error) {
+			return "", errors.New("Device \"ppp0\" does not exist.")
+		}
+		err = Healthy(&Session{Server: "198.51.100.1", Port: 1723, Interface: "ppp0"})
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "VPN interface [ppp0] is gone", err.Error())
+		}
+
+		run = func(name string, args ...string) (string, error) {
+			return "3: wlan0: <BROADCAST,MULTICAST> mtu 1500 qdisc noqueue state DOWN mode DORMANT", nil
+		}
+		err = Healthy(&Session{Server: "198.51.100.1", Port: 1723, Interface: "wlan0"})
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "VPN interface [wlan0] is down", err.Error())
+		}
+	}
+}
+
+func Test_Client_Reconnect(t *testing.T) {
+	var rules []string
+	original := firewalls
+	firewalls = []firewall{fakeFirewall{&rules}}
+	defer func() { firewalls = original }()
+	var commands []string
+	originalRun := run
+	defer func() { run = originalRun }()
+	run = func(name string, args ...string) (string, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		if len(args) > 2 && args[1] == "get" {
+			if args[2] == probe {
+				return probe + " dev ppp0 src 10.0.0.2", nil
+			}
+			return args[2] + " via 192.168.1.1 dev wlan0 src 192.168.1.23", nil
+		}
+		return "", nil
+	}
+
+	dir := tempDir(t)
+	options := config.Options{
+		ConnectCmd:    [][]string{{"connect", "$IP"}},
+		DisconnectCmd: [][]string{{"disconnect", "$IP"}},
+	}
+	connector, _ := Get("", "pptp", options, nil)
+	endpoint := Endpoint{Protocol: "pptp", IP: "198.51.100.1", Port: 1723}
+
+	// not connected at all
+	session, err := Reconnect(connector, endpoint, dir, options, nil)
+	assert.Nil(t, err)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, 1723, session.Port)
+	}
+
+	// the kill switch is carried over
+	session.Interface = "ppp0"
+	session.KillSwitch = "fake"
+	rules = []string{"drop"}
+	assert.Nil(t, save(dir, session))
+	commands = nil
+	session, err = Reconnect(connector, endpoint, dir, options, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, commands, "disconnect 198.51.100.1")
+	assert.Contains(t, commands, "connect 198.51.100.1")
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, "fake", session.KillSwitch)
+		assert.Equal(t, []string{"accept ppp0", "accept 198.51.100.1/32", "drop"}, rules)
+	}
+
+	_, err = Reconnect(connector, Endpoint{Protocol: "pptp", IP: "198.51.100.2"}, dir, options, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Can not reconnect to [198.51.100.2], connected to [198.51.100.1]", err.Error())
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// name of the nftables table and iptables chain holding the kill switch rules
+const killSwitchName = "easy-vpn"
+
+// firewall installs the kill switch rules with one particular tool
+type firewall interface {
+	Name() string
+	Available() bool
+	Enable(iface string, allowed []string) error // allowed are the CIDRs to reach outside of the VPN
+	Disable() error                              // must succeed even if the rules are not there
+}
+
+var firewalls = []firewall{
+	nftables{},
+	iptables{},
+}
+
+// EnableKillSwitch drops all outgoing traffic that does not go through the VPN interface,
+// except to the VPN server itself and the networks excluded from the VPN
+func EnableKillSwitch(session *Session, stateDir string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("The kill switch is not supported on %s", runtime.GOOS)
+	}
+	if len(session.Included) > 0 {
+		return fmt.Errorf("The kill switch can not be used together with split tunneling, only with excluded networks")
+	}
+
+	iface, err := tunnelInterface(session)
+	if err != nil {
+		return err
+	}
+	var fw firewall
+	for _, f := range firewalls {
+		if f.Available() {
+			fw = f
+			break
+		}
+	}
+	if fw == nil {
+		return fmt.Errorf("The kill switch needs either nft or iptables")
+	}
+
+	// remember the kill switch before installing it, so it can be recovered from if easy-vpn dies halfway
+	session.KillSwitch = fw.Name()
+	if err := save(stateDir, session); err != nil {
+		return err
+	}
+	allowed := append([]string{session.Server + "/32"}, session.Excluded...)
+	if err := fw.Enable(iface, allowed); err != nil {
+		fw.Disable()
+		return fmt.Errorf("Could not enable kill switch\n%v", err)
+	}
+	return nil
+}
+
+// disableKillSwitch removes the rules of the kill switch, whichever firewall installed them
+func disableKillSwitch(session *Session) error {
+	for _, fw := range firewalls {
+		if fw.Name() == session.KillSwitch {
+			if err := fw.Disable(); err != nil {
+				return fmt.Errorf("Could not disable kill switch\n%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// tunnelInterface is the interface of the VPN, through which internet traffic is routed while connected
+func tunnelInterface(session *Session) (string, error) {
+	if len(session.Interface) > 0 {
+		return session.Interface, nil
+	}
+	iface, err := routeInterface(probe)
+	if err != nil {
+		return "", err
+	}
+	physical, err := routeInterface(session.Server)
+	if err != nil {
+		return "", err
+	}
+	if iface == physical {
+		return "", fmt.Errorf("Internet traffic is routed through [%s] instead of the VPN, refusing to enable the kill switch", iface)
+	}
+	return iface, nil
+}
+
+// nftables keeps all rules in a table of its own, which is removed at once
+type nftables struct{}
+
+func (nftables) Name() string {
+	return "nftables"
+}
+
+func (nftables) Available() bool {
+	return installed("nft")
+}
+
+func (nftables) Enable(iface string, allowed []string) error {
+	commands := [][]string{
+		{"add", "table", "inet", killSwitchName},
+		{"add", "chain", "inet", killSwitchName, "output", "{", "type", "filter", "hook", "output", "priority", "0", ";", "policy", "drop", ";", "}"},
+		{"add", "rule", "inet", killSwitchName, "output", "oifname", "lo", "accept"},
+		{"add", "rule", "inet", killSwitchName, "output", "oifname", iface, "accept"},
+		// renewing the DHCP lease of the hotel network
+		{"add", "rule", "inet", killSwitchName, "output", "udp", "sport", "68", "udp", "dport", "67", "accept"},
+	}
+	for _, cidr := range allowed {
+		family := "ip"
+		if strings.Contains(cidr, ":") {
+			family = "ip6"
+		}
+		commands = append(commands, []string{"add", "rule", "inet", killSwitchName, "output", family, "daddr", cidr, "accept"})
+	}
+	for _, args := range commands {
+		if _, err := run("nft", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nftables) Disable() error {
+	out, err := run("nft", "list", "tables")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out+"\n", "table inet "+killSwitchName+"\n") {
+		return nil
+	}
+	_, err = run("nft", "delete", "table", "inet", killSwitchName)
+	return err
+}
+
+// iptables keeps all rules in a chain of its own, which OUTPUT jumps to, for IPv4 and IPv6 alike
+type iptables struct{}
+
+func (iptables) Name() string {
+	return "iptables"
+}
+
+func (iptables) Available() bool {
+	return installed("iptables")
+}
+
+func (iptables) Enable(iface string, allowed []string) error {
+	for _, binary := range iptablesBinaries() {
+		rules := [][]string{
+			{"-N", killSwitchName},
+			{"-A", killSwitchName, "-o", "lo", "-j", "ACCEPT"},
+			{"-A", killSwitchName, "-o", iface, "-j", "ACCEPT"},
+		}
+		if binary == "iptables" {
+			rules = append(rules, []string{"-A", killSwitchName, "-p", "udp", "--sport", "68", "--dport", "67", "-j", "ACCEPT"})
+		}
+		for _, cidr := range allowed {
+			if strings.Contains(cidr, ":") == (binary == "ip6tables") {
+				rules = append(rules, []string{"-A", killSwitchName, "-d", cidr, "-j", "ACCEPT"})
+			}
+		}
+		rules = append(rules,
+			[]string{"-A", killSwitchName, "-j", "DROP"},
+			[]string{"-I", "OUTPUT", "-j", killSwitchName})
+
+		for _, args := range rules {
+			if _, err := run(binary, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (iptables) Disable() error {
+	for _, binary := range iptablesBinaries() {
+		if _, err := run(binary, "-n", "-L", killSwitchName); err != nil {
+			continue // no such chain
+		}
+		// the jump might have been inserted more than once
+		for i := 0; i < 10; i++ {
+			if _, err := run(binary, "-D", "OUTPUT", "-j", killSwitchName); err != nil {
+				break
+			}
+		}
+		if _, err := run(binary, "-F", killSwitchName); err != nil {
+			return err
+		}
+		if _, err := run(binary, "-X", killSwitchName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func iptablesBinaries() []string {
+	if installed("ip6tables") {
+		return []string{"iptables", "ip6tables"}
+	}
+	return []string{"iptables"}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFirewall struct {
+	rules *[]string
+}
+
+func (fakeFirewall) Name() string {
+	return "fake"
+}
+
+func (fakeFirewall) Available() bool {
+	return true
+}
+
+func (f fakeFirewall) Enable(iface string, allowed []string) error {
+	*f.rules = []string{"accept " + iface, "accept " + strings.Join(allowed, ","), "drop"}
+	return nil
+}
+
+func (f fakeFirewall) Disable() error {
+	*f.rules = nil
+	return nil
+}
+
+func Test_Client_KillSwitch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the kill switch is only supported on linux")
+	}
+
+	var rules []string
+	original := firewalls
+	firewalls = []firewall{fakeFirewall{&rules}}
+	defer func() { firewalls = original }()
+	record(t, "")
+
+	dir := tempDir(t)
+	session := &Session{Connector: "custom", Protocol: "pptp", Server: "198.51.100.1", Interface: "ppp0", Excluded: []string{"192.168.0.0/16"}}
+	assert.Nil(t, save(dir, session))
+
+	assert.Nil(t, EnableKillSwitch(session, dir))
+	assert.Equal(t, []string{"accept ppp0", "accept 198.51.100.1/32,192.168.0.0/16", "drop"}, rules)
+
+	current, err := Current(dir)
+	assert.Nil(t, err)
+	if assert.NotNil(t, current) {
+		assert.Equal(t, "fake", current.KillSwitch)
+	}
+
+	_, err = Disconnect(dir, config.Options{Connector: "custom"}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, rules)
+}
+
+func Test_Client_KillSwitch_NotThroughVPN(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the kill switch is only supported on linux")
+	}
+
+	routes(t, map[string]string{probe: "wlan0", "198.51.100.1": "wlan0"})
+	err := EnableKillSwitch(&Session{Server: "198.51.100.1"}, tempDir(t))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Internet traffic is routed through [wlan0] instead of the VPN, refusing to enable the kill switch", err.Error())
+	}
+}
+
+func Test_Client_Recover(t *testing.T) {
+	rules := []string{"drop"}
+	original := firewalls
+	firewalls = []firewall{fakeFirewall{&rules}}
+	defer func() { firewalls = original }()
+	commands := record(t, "")
+
+	// without a session any leftover rules are removed anyway
+	dir := tempDir(t)
+	session, err := Recover(dir, config.Options{}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, session)
+	assert.Nil(t, rules)
+
+	rules = []string{"drop"}
+	assert.Nil(t, save(dir, &Session{Connector: "custom", Protocol: "pptp", Server: "198.51.100.1", KillSwitch: "fake"}))
+	session, err = Recover(dir, config.Options{DisconnectCmd: [][]string{{"poff", "$IP"}}}, nil)
+	assert.Nil(t, err)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, "198.51.100.1", session.Server)
+	}
+	assert.Nil(t, rules)
+	assert.Contains(t, *commands, "poff 198.51.100.1")
+
+	current, err := Current(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, current)
+}
+
+func Test_Client_Nftables(t *testing.T) {
+	commands := record(t, "")
+	assert.Nil(t, nftables{}.Enable("ppp0", []string{"198.51.100.1/32", "fd00::/8"}))
+	assert.Equal(t, 7, len(*commands))
+	assert.Equal(t, "nft add chain inet easy-vpn output { type filter hook output priority 0 ; policy drop ; }", (*commands)[1])
+	assert.Equal(t, "nft add rule inet easy-vpn output oifname ppp0 accept", (*commands)[3])
+	assert.Equal(t, "nft add rule inet easy-vpn output ip daddr 198.51.100.1/32 accept", (*commands)[5])
+	assert.Equal(t, "nft add rule inet easy-vpn output ip6 daddr fd00::/8 accept", (*commands)[6])
+
+	// no table, nothing to delete
+	*commands = nil
+	assert.Nil(t, nftables{}.Disable())
+	assert.Equal(t, []string{"nft list tables"}, *commands)
+
+	original := run
+	defer func() { run = original }()
+	*commands = nil
+	run = func(name string, args ...string) (string, error) {
+		*commands = append(*commands, strings.Join(append([]string{name}, args...), " "))
+		return "table inet filter\ntable inet easy-vpn", nil
+	}
+	assert.Nil(t, nftables{}.Disable())
+	assert.Equal(t, []string{"nft list tables", "nft delete table inet easy-vpn"}, *commands)
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+)
+
+// resolvConf is a variable so tests can use a file of their own
+var resolvConf = "/etc/resolv.conf"
+
+// saveNetwork remembers the default routes and DNS configuration, so they can be restored on disconnect
+func saveNetwork(session *Session) error {
+	if data, err := ioutil.ReadFile(resolvConf); err == nil {
+		session.ResolvConf = string(data)
+	}
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	out, err := run("ip", "route", "show", "default")
+	if err != nil {
+		return fmt.Errorf("Could not read routing table\n%v", err)
+	}
+	session.Routes, session.Gateway = parseDefaultRoutes(out)
+	return nil
+}
+
+// routeThrough sends all traffic through the VPN interface, except for the traffic to the VPN server itself
+func routeThrough(session *Session, iface string) error {
+	session.Interface = iface
+	if len(session.Gateway) > 0 {
+		if _, err := run("ip", "route", "replace", session.Server+"/32", "via", session.Gateway); err != nil {
+			return err
+		}
+	}
+	_, err := run("ip", "route", "replace", "default", "dev", iface)
+	return err
+}
+
+// restoreNetwork undoes routeThrough and applyRouting, any other changes of the default routes, and changes of the DNS configuration
+func restoreNetwork(session *Session) error {
+	removeRouting(session)
+	if runtime.GOOS == "linux" && len(session.Routes) > 0 {
+		if len(session.Interface) > 0 && len(session.Gateway) > 0 {
+			run("ip", "route", "del", session.Server+"/32", "via", session.Gateway) // might be gone together with the interface
+		}
+
+		out, err := run("ip", "route", "show", "default")
+		if err != nil {
+			return fmt.Errorf("Could not read routing table\n%v", err)
+		}
+		if current, _ := parseDefaultRoutes(out); !equal(current, session.Routes) {
+			for _, route := range current {
+				run("ip", append([]string{"route", "del"}, strings.Fields(route)...)...)
+			}
+			for _, route := range session.Routes {
+				if _, err := run("ip", append([]string{"route", "replace"}, strings.Fields(route)...)...); err != nil {
+					return fmt.Errorf("Could not restore route [%s]\n%v", route, err)
+				}
+			}
+		}
+	}
+
+	if len(session.ResolvConf) > 0 {
+		current, _ := ioutil.ReadFile(resolvConf)
+		if string(current) != session.ResolvConf {
+			if err := ioutil.WriteFile(resolvConf, []byte(session.ResolvConf), 0644); err != nil {
+				return fmt.Errorf("Could not restore DNS configuration: %s\n%v", resolvConf, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseDefaultRoutes reads the output of "ip route show default", like "default via 192.168.1.1 dev wlan0 proto dhcp",
+// returning all routes and the gateway of the first one
+func parseDefaultRoutes(out string) (routes []string, gateway string) {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "default") {
+			continue
+		}
+		routes = append(routes, line)
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields)-1 && len(gateway) == 0; i++ {
+			if fields[i] == "via" {
+				gateway = fields[i+1]
+			}
+		}
+	}
+	return routes, gateway
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
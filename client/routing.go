@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+
+	"github.com/JamesClonk/easy-vpn/config"
+)
+
+// Routing is the traffic going through the VPN, with all domains resolved to CIDRs
+type Routing struct {
+	Include []string // only this goes through the VPN, everything if empty
+	Exclude []string // this never goes through the VPN
+}
+
+// lookup is a variable so tests can resolve domains of their own
+var lookup = net.LookupIP
+
+// Split tells whether only selected traffic goes through the VPN
+func (r Routing) Split() bool {
+	return len(r.Include) > 0
+}
+
+// ResolveRouting validates the configured CIDRs and resolves the configured domains into host routes
+func ResolveRouting(cfg config.Routing) (routing Routing, err error) {
+	if routing.Include, err = resolve(cfg.Include, cfg.IncludeDomains); err != nil {
+		return routing, err
+	}
+	if routing.Exclude, err = resolve(cfg.Exclude, cfg.ExcludeDomains); err != nil {
+		return routing, err
+	}
+	return routing, nil
+}
+
+func resolve(cidrs []string, domains []string) (result []string, err error) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR in routing configuration: %q", cidr)
+		}
+		result = append(result, network.String())
+	}
+	for _, domain := range domains {
+		ips, err := lookup(domain)
+		if err != nil {
+			return nil, fmt.Errorf("Could not resolve domain: %s\n%v", domain, err)
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				result = append(result, ip.String()+"/32")
+			} else {
+				result = append(result, ip.String()+"/128")
+			}
+		}
+	}
+	return result, nil
+}
+
+// applyRouting narrows the routes through the VPN down to the included networks,
+// and routes the excluded networks through the gateway from before connecting
+func applyRouting(session *Session, routing Routing) error {
+	if !routing.Split() && len(routing.Exclude) == 0 {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("Split tunneling is not supported on %s", runtime.GOOS)
+	}
+
+	iface, err := tunnelInterface(session)
+	if err != nil {
+		return err
+	}
+	session.Interface = iface
+
+	if routing.Split() {
+		// take the default routes away from the VPN, back to what they were before
+		out, err := run("ip", "route", "show", "default")
+		if err != nil {
+			return fmt.Errorf("Could not read routing table\n%v", err)
+		}
+		current, _ := parseDefaultRoutes(out)
+		for _, route := range current {
+			if contains(strings.Fields(route), iface) {
+				if _, err := run("ip", append([]string{"route", "del"}, strings.Fields(route)...)...); err != nil {
+					return err
+				}
+			}
+		}
+		for _, route := range session.Routes {
+			if _, err := run("ip", append([]string{"route", "replace"}, strings.Fields(route)...)...); err != nil {
+				return fmt.Errorf("Could not restore route [%s]\n%v", route, err)
+			}
+		}
+
+		for _, cidr := range routing.Include {
+			if _, err := run("ip", "route", "replace", cidr, "dev", iface); err != nil {
+				return err
+			}
+			session.Included = append(session.Included, cidr)
+		}
+	}
+
+	if len(routing.Exclude) > 0 && len(session.Gateway) == 0 {
+		return fmt.Errorf("Could not find the gateway to route excluded networks through")
+	}
+	for _, cidr := range routing.Exclude {
+		if _, err := run("ip", "route", "replace", cidr, "via", session.Gateway); err != nil {
+			return err
+		}
+		session.Excluded = append(session.Excluded, cidr)
+	}
+	return nil
+}
+
+// removeRouting deletes the routes added by applyRouting, those of the included networks might be gone with the interface
+func removeRouting(session *Session) {
+	for _, cidr := range session.Included {
+		run("ip", "route", "del", cidr, "dev", session.Interface)
+	}
+	for _, cidr := range session.Excluded {
+		run("ip", "route", "del", cidr, "via", session.Gateway)
+	}
+}
+
+// excluded tells whether an IP address is within one of the excluded networks
+func excluded(session *Session, ip net.IP) bool {
+	for _, cidr := range session.Excluded {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIPs replaces the AllowedIPs of all peers of a wg-quick configuration with the included networks
+func allowedIPs(wgConfig string, include []string) string {
+	lines := strings.Split(wgConfig, "\n")
+	for i, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "AllowedIPs" {
+			lines[i] = "AllowedIPs = " + strings.Join(include, ", ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Client_ResolveRouting(t *testing.T) {
+	original := lookup
+	lookup = func(host string) ([]net.IP, error) {
+		if host == "intranet.example.com" {
+			return []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("fd00::1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookup = original }()
+
+	routing, err := ResolveRouting(config.Routing{
+		Include:        []string{"10.20.30.40/16"},
+		ExcludeDomains: []string{"intranet.example.com"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, routing.Split())
+	assert.Equal(t, []string{"10.20.0.0/16"}, routing.Include)
+	assert.Equal(t, []string{"10.1.2.3/32", "fd00::1/128"}, routing.Exclude)
+
+	_, err = ResolveRouting(config.Routing{Exclude: []string{"192.168.1.1"}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `Invalid CIDR in routing configuration: "192.168.1.1"`, err.Error())
+	}
+	_, err = ResolveRouting(config.Routing{IncludeDomains: []string{"unknown.example.com"}})
+	assert.NotNil(t, err)
+
+	routing, err = ResolveRouting(config.Routing{})
+	assert.Nil(t, err)
+	assert.False(t, routing.Split())
+}
+
+func Test_Client_ApplyRouting(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("routes are only handled on linux")
+	}
+
+	commands := record(t, "default dev ppp0 scope link\ndefault via 192.168.1.1 dev wlan0 proto dhcp metric 600\n")
+	session := &Session{
+		Server:    "198.51.100.1",
+		Interface: "ppp0",
+		Gateway:   "192.168.1.1",
+		Routes:    []string{"default via 192.168.1.1 dev wlan0 proto dhcp metric 600"},
+	}
+	assert.Nil(t, applyRouting(session, Routing{Include: []string{"10.20.0.0/16"}, Exclude: []string{"10.20.1.0/24"}}))
+	assert.Equal(t, []string{
+		"ip route show default",
+		"ip route del default dev ppp0 scope link",
+		"ip route replace default via 192.168.1.1 dev wlan0 proto dhcp metric 600",
+		"ip route replace 10.20.0.0/16 dev ppp0",
+		"ip route replace 10.20.1.0/24 via 192.168.1.1",
+	}, *commands)
+	assert.Equal(t, []string{"10.20.0.0/16"}, session.Included)
+	assert.Equal(t, []string{"10.20.1.0/24"}, session.Excluded)
+	assert.True(t, excluded(session, net.ParseIP("10.20.1.53")))
+	assert.False(t, excluded(session, net.ParseIP("10.20.2.53")))
+
+	*commands = nil
+	removeRouting(session)
+	assert.Equal(t, []string{
+		"ip route del 10.20.0.0/16 dev ppp0",
+		"ip route del 10.20.1.0/24 via 192.168.1.1",
+	}, *commands)
+
+	// nothing to do without any routing configuration
+	*commands = nil
+	assert.Nil(t, applyRouting(&Session{}, Routing{}))
+	assert.Nil(t, *commands)
+}
+
+func Test_Client_AllowedIPs(t *testing.T) {
+	wgConfig := "[Interface]\nPrivateKey = abc\n\n[Peer]\nPublicKey = xyz\nAllowedIPs = 0.0.0.0/0, ::/0\nEndpoint = 198.51.100.1:51820"
+	assert.Equal(t, "[Interface]\nPrivateKey = abc\n\n[Peer]\nPublicKey = xyz\nAllowedIPs = 10.20.0.0/16, 10.30.0.0/16\nEndpoint = 198.51.100.1:51820",
+		allowedIPs(wgConfig, []string{"10.20.0.0/16", "10.30.0.0/16"}))
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DEFAULT_VERIFY_URL answers with the public IP address of the client asking, if no verify_ip_url is configured
+const DEFAULT_VERIFY_URL = "https://api.ipify.org"
+
+// probe is a public address to find out which interface internet traffic is routed through
+const probe = "1.1.1.1"
+
+// resolvedStub is the local resolver of systemd-resolved, resolvedConf lists the nameservers it forwards to,
+// it is a variable so tests can use a file of their own
+const resolvedStub = "127.0.0.53"
+
+var resolvedConf = "/run/systemd/resolve/resolv.conf"
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// Verification is what was found out about a VPN connection, to tell whether traffic actually goes through it
+type Verification struct {
+	EgressBefore string        // public IP address before connecting
+	EgressAfter  string        // public IP address through the VPN, expected to be the one of the VPN server
+	Latency      time.Duration // roundtrip of the egress IP request through the VPN
+	Interface    string        // through which internet traffic is routed
+	Nameservers  []string
+	Leaks        []string // nameservers which are not reached through the VPN
+}
+
+// VerificationError tells why traffic does not go through the VPN
+type VerificationError struct {
+	Reason       string
+	Verification *Verification
+}
+
+func (e *VerificationError) Error() string {
+	return "VPN verification failed: " + e.Reason
+}
+
+// EgressIP asks a "what is my IP" endpoint for the public IP address of this machine, and how long it took
+func EgressIP(url string) (string, time.Duration, error) {
+	if len(url) == 0 {
+		url = DEFAULT_VERIFY_URL
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not get public IP address from %s\n%v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	latency := time.Since(start)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not get public IP address from %s\n%v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Could not get public IP address from %s\n%s", url, resp.Status)
+	}
+
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", 0, fmt.Errorf("Could not get public IP address from %s\nInvalid answer: %q", url, ip)
+	}
+	return ip, latency, nil
+}
+
+// Verify checks that traffic of this machine goes through the VPN: its public IP address has to be the one
+// of the VPN server and differ from the one before connecting, and no nameserver may be reached outside the VPN
+func Verify(session *Session, url string, before string) (*Verification, error) {
+	v := &Verification{EgressBefore: before}
+	if len(session.Included) > 0 {
+		// the "what is my IP" endpoint is most likely not reached through the VPN
+		return v, checkIncluded(session, v)
+	}
+
+	after, latency, err := EgressIP(url)
+	if err != nil {
+		return v, &VerificationError{Reason: fmt.Sprintf("no internet access through the VPN\n%v", err), Verification: v}
+	}
+	v.EgressAfter, v.Latency = after, latency
+
+	if len(before) > 0 && after == before {
+		return v, &VerificationError{
+			Reason:       fmt.Sprintf("public IP address is still [%s], traffic does not go through the VPN", after),
+			Verification: v,
+		}
+	}
+	if after != session.Server {
+		return v, &VerificationError{
+			Reason:       fmt.Sprintf("public IP address is [%s] instead of the one of the VPN server [%s]", after, session.Server),
+			Verification: v,
+		}
+	}
+
+	if err := checkDNS(session, v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// checkDNS compares the interface to each nameserver with the one internet traffic is routed through
+func checkDNS(session *Session, v *Verification) error {
+	data, err := ioutil.ReadFile(resolvConf)
+	if err != nil {
+		return nil // nothing to check, e.g. on windows
+	}
+	v.Nameservers = parseNameservers(string(data))
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	v.Interface, err = routeInterface(probe)
+	if err != nil {
+		return err
+	}
+	physical, err := routeInterface(session.Server)
+	if err != nil {
+		return err
+	}
+	if v.Interface == physical {
+		return &VerificationError{
+			Reason:       fmt.Sprintf("internet traffic is routed through [%s] instead of the VPN", v.Interface),
+			Verification: v,
+		}
+	}
+
+	for _, nameserver := range upstreamNameservers(v.Nameservers) {
+		ip := net.ParseIP(nameserver)
+		if ip == nil || ip.IsLoopback() {
+			continue // another local resolver, which nameservers it forwards to is unknown
+		}
+		if excluded(session, ip) {
+			continue // like the nameserver of the corporate LAN
+		}
+		iface, gateway, err := route(nameserver)
+		if err != nil {
+			return err
+		}
+		if iface == v.Interface {
+			continue
+		}
+		if len(gateway) == 0 && !session.DNS {
+			continue // a resolver of the LAN, which is only a leak if the connector was supposed to switch DNS to the VPN
+		}
+		v.Leaks = append(v.Leaks, nameserver)
+	}
+	if len(v.Leaks) > 0 {
+		return &VerificationError{
+			Reason:       fmt.Sprintf("DNS leak, nameservers [%s] are not reached through the VPN", strings.Join(v.Leaks, ", ")),
+			Verification: v,
+		}
+	}
+	return nil
+}
+
+// checkIncluded makes sure the included networks are routed through the VPN, when split tunneling
+func checkIncluded(session *Session, v *Verification) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	v.Interface = session.Interface
+	for _, cidr := range session.Included {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		iface, err := routeInterface(ip.String())
+		if err != nil {
+			return err
+		}
+		if iface != session.Interface {
+			return &VerificationError{
+				Reason:       fmt.Sprintf("[%s] is routed through [%s] instead of the VPN", cidr, iface),
+				Verification: v,
+			}
+		}
+	}
+	return nil
+}
+
+// upstreamNameservers replaces the stub resolver of systemd-resolved by the nameservers it forwards to
+func upstreamNameservers(nameservers []string) (upstream []string) {
+	for _, nameserver := range nameservers {
+		if nameserver != resolvedStub {
+			upstream = append(upstream, nameserver)
+			continue
+		}
+		data, err := ioutil.ReadFile(resolvedConf)
+		if err != nil {
+			continue // not systemd-resolved after all
+		}
+		for _, forwarded := range parseNameservers(string(data)) {
+			if !contains(upstream, forwarded) {
+				upstream = append(upstream, forwarded)
+			}
+		}
+	}
+	return upstream
+}
+
+// routeInterface reads the interface from "ip route get", like "1.1.1.1 via 192.168.1.1 dev wlan0 src 192.168.1.23"
+func routeInterface(ip string) (string, error) {
+	iface, _, err := route(ip)
+	return iface, err
+}
+
+// route reads the interface and gateway from "ip route get", the gateway is empty if ip is on-link
+func route(ip string) (iface string, gateway string, err error) {
+	out, err := run("ip", "route", "get", ip)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not read routing table\n%v", err)
+	}
+	fields := strings.Fields(out)
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "dev":
+			iface = fields[i+1]
+		case "via":
+			gateway = fields[i+1]
+		}
+	}
+	if len(iface) == 0 {
+		return "", "", fmt.Errorf("Could not find route to [%s]\n%s", ip, out)
+	}
+	return iface, gateway, nil
+}
+
+// parseNameservers reads all "nameserver <ip>" lines of a resolv.conf
+func parseNameservers(data string) (nameservers []string) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			nameservers = append(nameservers, fields[1])
+		}
+	}
+	return nameservers
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func whatIsMyIP(ip string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, ip)
+	}))
+}
+
+// routes stubs "ip route get", answering with the given interface per destination, the gateway 192.168.1.1 is on-link
+func routes(t *testing.T, ifaces map[string]string) {
+	original := run
+	run = func(name string, args ...string) (string, error) {
+		ip := args[len(args)-1]
+		if ip == "192.168.1.1" {
+			return fmt.Sprintf("%s dev %s src 192.168.1.23 uid 0\n    cache\n", ip, ifaces[ip]), nil
+		}
+		return fmt.Sprintf("%s via 192.168.1.1 dev %s src 192.168.1.23 uid 0\n    cache\n", ip, ifaces[ip]), nil
+	}
+	t.Cleanup(func() { run = original })
+}
+
+func Test_Client_EgressIP(t *testing.T) {
+	server := whatIsMyIP("203.0.113.7")
+	defer server.Close()
+
+	ip, latency, err := EgressIP(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+	assert.True(t, latency > 0)
+
+	garbage := whatIsMyIP("<html>hello</html>")
+	defer garbage.Close()
+	_, _, err = EgressIP(garbage.URL)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Invalid answer")
+	}
+}
+
+func Test_Client_Verify(t *testing.T) {
+	dir := tempDir(t)
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	defer func() { resolvConf = "/etc/resolv.conf" }()
+	resolvedConf = filepath.Join(dir, "resolved.conf")
+	defer func() { resolvedConf = "/run/systemd/resolve/resolv.conf" }()
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("# comment\nnameserver 127.0.0.53\nnameserver 10.0.0.1\noptions edns0\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(resolvedConf, []byte("nameserver 10.0.0.2\n"), 0644))
+	routes(t, map[string]string{probe: "ppp0", "10.0.0.1": "ppp0", "10.0.0.2": "ppp0", "198.51.100.1": "wlan0"})
+
+	server := whatIsMyIP("198.51.100.1")
+	defer server.Close()
+
+	v, err := Verify(&Session{Server: "198.51.100.1"}, server.URL, "203.0.113.7")
+	assert.Nil(t, err)
+	if assert.NotNil(t, v) {
+		assert.Equal(t, "203.0.113.7", v.EgressBefore)
+		assert.Equal(t, "198.51.100.1", v.EgressAfter)
+		assert.Equal(t, []string{"127.0.0.53", "10.0.0.1"}, v.Nameservers)
+		assert.Nil(t, v.Leaks)
+		if runtime.GOOS == "linux" {
+			assert.Equal(t, "ppp0", v.Interface)
+		}
+	}
+
+	// still the same public IP
+	_, err = Verify(&Session{Server: "198.51.100.1"}, server.URL, "198.51.100.1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "VPN verification failed: public IP address is still [198.51.100.1], traffic does not go through the VPN", err.Error())
+	}
+
+	// somebody else's public IP
+	_, err = Verify(&Session{Server: "198.51.100.2"}, server.URL, "203.0.113.7")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "VPN verification failed: public IP address is [198.51.100.1] instead of the one of the VPN server [198.51.100.2]", err.Error())
+	}
+}
+
+func Test_Client_Verify_DNSLeak(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("routes are only checked on linux")
+	}
+
+	dir := tempDir(t)
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	defer func() { resolvConf = "/etc/resolv.conf" }()
+	resolvedConf = filepath.Join(dir, "resolved.conf")
+	defer func() { resolvedConf = "/run/systemd/resolve/resolv.conf" }()
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("nameserver 192.168.1.1\nnameserver 8.8.8.8\n"), 0644))
+	routes(t, map[string]string{probe: "ppp0", "8.8.8.8": "ppp0", "9.9.9.9": "wlan0", "192.168.1.1": "wlan0", "198.51.100.1": "wlan0"})
+
+	server := whatIsMyIP("198.51.100.1")
+	defer server.Close()
+
+	v, err := Verify(&Session{Server: "198.51.100.1", DNS: true}, server.URL, "203.0.113.7")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "VPN verification failed: DNS leak, nameservers [192.168.1.1] are not reached through the VPN", err.Error())
+		assert.Equal(t, []string{"192.168.1.1"}, v.Leaks)
+	}
+
+	// the resolver of the LAN is left alone by a connector which does not switch DNS
+	_, err = Verify(&Session{Server: "198.51.100.1"}, server.URL, "203.0.113.7")
+	assert.Nil(t, err)
+
+	// systemd-resolved forwarding past the VPN
+	assert.Nil(t, ioutil.WriteFile(resolvConf, []byte("nameserver 127.0.0.53\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(resolvedConf, []byte("nameserver 8.8.8.8\nnameserver 9.9.9.9\n"), 0644))
+	v, err = Verify(&Session{Server: "198.51.100.1"}, server.URL, "203.0.113.7")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, []string{"127.0.0.53"}, v.Nameservers)
+		assert.Equal(t, []string{"9.9.9.9"}, v.Leaks)
+	}
+
+	// not routed through the VPN at all
+	routes(t, map[string]string{probe: "wlan0", "198.51.100.1": "wlan0"})
+	_, err = Verify(&Session{Server: "198.51.100.1"}, server.URL, "")
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "internet traffic is routed through [wlan0] instead of the VPN"))
+	}
+}
+
+func Test_Client_ParseNameservers(t *testing.T) {
+	assert.Equal(t, []string{"1.1.1.1", "2606:4700:4700::1111"},
+		parseNameservers("search example.com\nnameserver 1.1.1.1\n#nameserver 9.9.9.9\nnameserver 2606:4700:4700::1111\n"))
+	assert.Nil(t, parseNameservers(""))
+}
@@ -1,17 +1,38 @@
 package config
 
 import (
+	"fmt"
+	"os/user"
+	"strings"
+
 	"github.com/BurntSushi/toml"
 )
 
+// DEFAULT_STATE_DIR is where easy-vpn remembers local state, like the current VPN connection, if no state_dir is configured
+const DEFAULT_STATE_DIR = "~/.easy-vpn"
+
+// DEFAULT_SSH_USER and DEFAULT_SSH_PORT are what a newly created virtual machine accepts, if no ssh_user or ssh_port is configured
+const (
+	DEFAULT_SSH_USER = "root"
+	DEFAULT_SSH_PORT = 22
+)
+
 type Config struct {
 	Provider         string              `toml:"provider"`
 	PrivateKeyFile   string              `toml:"ssh_private_key"`
 	PublicKeyFile    string              `toml:"ssh_public_key"`
+	SshUser          string              `toml:"ssh_user"`          // created during provisioning, with sudo, root login is disabled then
+	SshPort          int                 `toml:"ssh_port"`          // sshd is moved to this port during provisioning
+	SshEphemeralKey  bool                `toml:"ssh_ephemeral_key"` // generate an ssh-key per vm, deleted again together with it
 	SelfDestructFile string              `toml:"self_destruct"`
 	Sleep            int                 `toml:"sleeptime"`
+	StateDir         string              `toml:"state_dir"`
 	Providers        map[string]Provider `toml:"providers"`
 	Options          Options             `toml:"options"`
+	Timeouts         Timeouts            `toml:"timeouts"`
+	Credentials      Credentials         `toml:"credentials"`
+	Routing          Routing             `toml:"routing"`
+	Firewall         Firewall            `toml:"firewall"`
 }
 
 type Provider struct {
@@ -22,9 +43,42 @@ type Provider struct {
 }
 
 type Options struct {
-	Uptime      int        `toml:"max_uptime"`
-	Autoconnect bool       `toml:"vpn_autoconnect"`
-	ConnectCmd  [][]string `toml:"autoconnect_cmd"`
+	Uptime        int        `toml:"max_uptime"`
+	Autoconnect   bool       `toml:"vpn_autoconnect"`
+	Connector     string     `toml:"vpn_connector"` // "pptpsetup", "nmcli", "wg-quick" or "custom", detected if empty
+	ConnectCmd    [][]string `toml:"autoconnect_cmd"`
+	DisconnectCmd [][]string `toml:"autodisconnect_cmd"`
+	VerifyURL     string     `toml:"verify_ip_url"` // answers with the public IP address of the client asking
+	KillSwitch    bool       `toml:"kill_switch"`   // drop all traffic outside the VPN while connected
+}
+
+// Timeouts are given in seconds, zero means the default of the respective phase
+type Timeouts struct {
+	Installation int `toml:"installation"` // until a newly created vm shows up
+	Status       int `toml:"status"`       // until the vm is active
+	Readyness    int `toml:"readyness"`    // until the vm is done with its package updates
+	Retries      int `toml:"retries"`      // how often to replace a stuck vm with a new one
+}
+
+// Credentials configures how VPN passwords are generated, zero values mean the defaults of package rng
+type Credentials struct {
+	PasswordLength  int      `toml:"password_length"`
+	PasswordClasses []string `toml:"password_classes"`
+	PassphraseWords int      `toml:"passphrase_words"` // generate passphrases of this many words instead, if > 0
+}
+
+// Routing selects the traffic going through the VPN, everything if there is nothing to include
+type Routing struct {
+	Include        []string `toml:"include"` // CIDRs
+	Exclude        []string `toml:"exclude"` // CIDRs
+	IncludeDomains []string `toml:"include_domains"`
+	ExcludeDomains []string `toml:"exclude_domains"`
+}
+
+// Firewall configures the firewall of the virtual machine
+type Firewall struct {
+	SshAllow  []string `toml:"ssh_allow"`  // CIDRs allowed to SSH into the virtual machine, besides the public IP of the client
+	VpnSubnet string   `toml:"vpn_subnet"` // addresses of the VPN clients, which are NATed
 }
 
 func LoadConfiguration(filename string) (config *Config, err error) {
@@ -33,3 +87,53 @@ func LoadConfiguration(filename string) (config *Config, err error) {
 	}
 	return
 }
+
+// WithRegion returns a copy of the configuration whose active provider uses the given region
+func (c *Config) WithRegion(region string) *Config {
+	cfg := *c
+	cfg.Providers = make(map[string]Provider, len(c.Providers))
+	for name, provider := range c.Providers {
+		cfg.Providers[name] = provider
+	}
+
+	provider := cfg.Providers[cfg.Provider]
+	provider.Region = region
+	cfg.Providers[cfg.Provider] = provider
+	return &cfg
+}
+
+// GetStateDir returns the configured state directory, or the default one if there is none
+func (c *Config) GetStateDir() string {
+	if len(c.StateDir) == 0 {
+		return DEFAULT_STATE_DIR
+	}
+	return c.StateDir
+}
+
+// GetSshUser returns the configured SSH user, or root if there is none
+func (c *Config) GetSshUser() string {
+	if len(c.SshUser) == 0 {
+		return DEFAULT_SSH_USER
+	}
+	return c.SshUser
+}
+
+// GetSshPort returns the configured SSH port, or 22 if there is none
+func (c *Config) GetSshPort() int {
+	if c.SshPort == 0 {
+		return DEFAULT_SSH_PORT
+	}
+	return c.SshPort
+}
+
+// ExpandHome replaces a leading tilde (~) of a path with the home directory of the current user
+func ExpandHome(path string) (string, error) {
+	if strings.HasPrefix(path, `~`) {
+		usr, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("Could not get information about current user\n%v", err)
+		}
+		path = strings.Replace(path, `~`, usr.HomeDir, 1)
+	}
+	return path, nil
+}
@@ -33,6 +33,41 @@ func Test_Config_LoadConfiguration_Options(t *testing.T) {
 		assert.Equal(t, false, cfg.Options.Autoconnect)
 		assert.Equal(t, [][]string{[]string{"connect", "$IP", "$USER", "$PASS"},
 			[]string{"disconnect"}}, cfg.Options.ConnectCmd)
+		assert.Equal(t, "https://ip.example.com", cfg.Options.VerifyURL)
+		assert.Equal(t, true, cfg.Options.KillSwitch)
+	}
+}
+
+func Test_Config_LoadConfiguration_Timeouts(t *testing.T) {
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, 120, cfg.Timeouts.Installation)
+		assert.Equal(t, 240, cfg.Timeouts.Status)
+		assert.Equal(t, 0, cfg.Timeouts.Readyness)
+		assert.Equal(t, 2, cfg.Timeouts.Retries)
+	}
+}
+
+func Test_Config_LoadConfiguration_Credentials(t *testing.T) {
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, 16, cfg.Credentials.PasswordLength)
+		assert.Equal(t, []string{"lower", "digits", "symbols"}, cfg.Credentials.PasswordClasses)
+		assert.Equal(t, 0, cfg.Credentials.PassphraseWords)
+	}
+}
+
+func Test_Config_LoadConfiguration_Routing(t *testing.T) {
+	if assert.NotNil(t, cfg) {
+		assert.Nil(t, cfg.Routing.Include)
+		assert.Equal(t, []string{"192.168.0.0/16", "10.1.0.0/16"}, cfg.Routing.Exclude)
+		assert.Nil(t, cfg.Routing.IncludeDomains)
+		assert.Equal(t, []string{"intranet.example.com"}, cfg.Routing.ExcludeDomains)
+	}
+}
+
+func Test_Config_LoadConfiguration_Firewall(t *testing.T) {
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, []string{"203.0.113.0/24"}, cfg.Firewall.SshAllow)
+		assert.Equal(t, "10.99.99.0/24", cfg.Firewall.VpnSubnet)
 	}
 }
 
@@ -60,3 +95,39 @@ func Test_Config_LoadConfiguration_NoFile(t *testing.T) {
 	assert.Nil(t, cfg)
 	assert.NotNil(t, err)
 }
+
+func Test_Config_WithRegion(t *testing.T) {
+	if assert.NotNil(t, cfg) {
+		regional := cfg.WithRegion("99")
+		if assert.NotNil(t, regional) {
+			assert.Equal(t, "vultr", regional.Provider)
+			assert.Equal(t, "99", regional.Providers["vultr"].Region)
+			assert.Equal(t, "xyzabcdefg999", regional.Providers["vultr"].ApiKey)
+			assert.Equal(t, "nyc3", regional.Providers["digitalocean"].Region)
+		}
+		assert.Equal(t, "7", cfg.Providers["vultr"].Region)
+	}
+}
+
+func Test_Config_GetStateDir(t *testing.T) {
+	assert.Equal(t, DEFAULT_STATE_DIR, (&Config{}).GetStateDir())
+	assert.Equal(t, "/tmp/easy-vpn", (&Config{StateDir: "/tmp/easy-vpn"}).GetStateDir())
+}
+
+func Test_Config_GetSshUserAndPort(t *testing.T) {
+	assert.Equal(t, "root", (&Config{}).GetSshUser())
+	assert.Equal(t, 22, (&Config{}).GetSshPort())
+	assert.Equal(t, "easyvpn", (&Config{SshUser: "easyvpn"}).GetSshUser())
+	assert.Equal(t, 2222, (&Config{SshPort: 2222}).GetSshPort())
+}
+
+func Test_Config_ExpandHome(t *testing.T) {
+	path, err := ExpandHome("~/test/123.txt")
+	assert.Nil(t, err)
+	assert.NotEqual(t, "~/test/123.txt", path)
+	assert.Contains(t, path, "/test/123.txt")
+
+	path, err = ExpandHome("/tmp/123.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/123.txt", path)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/codegangsta/cli"
+)
+
+func showCredentials(c *cli.Context) {
+	deployment, err := getEngine(c).Credentials(context.Background(), c.Bool("rotate"))
+	if err != nil {
+		fail(c, err)
+	}
+
+	if isStructuredOutput(c) {
+		printDocument(c, newUpDocument(deployment))
+		return
+	}
+
+	printMachine(deployment.VM)
+	if c.Bool("rotate") {
+		log.Printf("docker-pptpd credentials rotated, username [%s] now has password [%s]\n", deployment.Username, deployment.Password)
+	} else {
+		log.Printf("docker-pptpd is running, with username [%s] and password [%s]\n", deployment.Username, deployment.Password)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/ssh"
+	"github.com/codegangsta/cli"
+)
+
+type destroyTarget struct {
+	Provider provider.API
+	VM       provider.VM
+}
+
+type destroyDocument struct {
+	VM       vmDocument `json:"vm" yaml:"vm"`
+	Provider string     `json:"provider" yaml:"provider"`
+	Error    string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type downDocument struct {
+	Destroyed []destroyDocument `json:"destroyed" yaml:"destroyed"`
+	Failed    []destroyDocument `json:"failed" yaml:"failed"`
+}
+
+func destroyVpn(c *cli.Context) {
+	var olderThan time.Duration
+	if c.IsSet("older-than") {
+		var err error
+		olderThan, err = time.ParseDuration(c.String("older-than"))
+		if err != nil {
+			fail(c, fmt.Errorf("Invalid value for --older-than option given: %v", c.String("older-than")))
+		}
+	}
+
+	var providers []provider.API
+	if c.Bool("all") {
+		providers = configuredProviders(c)
+	} else {
+		providers = []provider.API{getProvider(c)}
+	}
+
+	var targets []destroyTarget
+	var listingErrors []destroyDocument
+	var listed []provider.API
+	var existing [][]provider.VM
+	for _, p := range providers {
+		machines, err := p.GetAllVMs()
+		if err != nil {
+			listingErrors = append(listingErrors, destroyDocument{
+				Provider: p.GetProviderName(),
+				Error:    fmt.Sprintf("Could not retrieve list of virtual machines: %v", err),
+			})
+			continue
+		}
+		for _, machine := range selectMachines(machines, c.Bool("all"), olderThan, time.Now()) {
+			targets = append(targets, destroyTarget{Provider: p, VM: machine})
+		}
+		listed, existing = append(listed, p), append(existing, machines)
+	}
+
+	doc, declined := destroyAll(c, targets, c.Bool("yes"))
+	// the keys of all machines are only deleted unasked with --yes, as none of them were confirmed
+	if !declined && (len(targets) > 0 || !c.Bool("all") || c.Bool("yes")) {
+		for i, p := range listed {
+			deleteLeftoverKeys(messages(c), p, existing[i], c.Bool("all"))
+		}
+	}
+	doc.Failed = append(listingErrors, doc.Failed...)
+	printDownSummary(c, doc)
+}
+
+// configuredProviders returns all providers of the configuration file that easy-vpn can talk to
+func configuredProviders(c *cli.Context) (providers []provider.API) {
+	cfg := parseGlobalOptions(c)
+
+	names := make([]string, 0, len(cfg.Providers))
+	for name := range cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		providerCfg := *cfg
+		providerCfg.Provider = name
+		p, err := newProvider(&providerCfg)
+		if err != nil {
+			fmt.Fprintf(messages(c), "Skipping provider [%s]: %v\n", name, err)
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// selectMachines returns the easy-vpn virtual machines that should be destroyed,
+// either only the single easy-vpn vm or all of them including the fleet,
+// optionally restricted to those created more than olderThan ago
+func selectMachines(machines []provider.VM, all bool, olderThan time.Duration, now time.Time) (selected []provider.VM) {
+	for _, machine := range machines {
+		if all {
+			if !easyvpn.IsEasyVpn(machine.Name) {
+				continue
+			}
+		} else if machine.Name != EASYVPN_IDENTIFIER {
+			continue
+		}
+
+		if olderThan > 0 {
+			// machines with unknown age are never considered old enough
+			if machine.Created.IsZero() || now.Sub(machine.Created) < olderThan {
+				continue
+			}
+		}
+		selected = append(selected, machine)
+	}
+	return selected
+}
+
+// deleteLeftoverKeys deletes the ephemeral ssh-keys of virtual machines that are gone already, e.g. because they self-destructed,
+// from the provider and the state directory. The keys of the remaining machines are deleted by Engine.Destroy.
+func deleteLeftoverKeys(out io.Writer, p provider.API, machines []provider.VM, all bool) {
+	existing := make(map[string]bool)
+	for _, machine := range machines {
+		existing[machine.Name] = true
+	}
+
+	// the key file of the single vm might be left even without a key at the provider
+	names := []string{EASYVPN_IDENTIFIER}
+	if all {
+		keys, err := p.GetInstalledSshKeys()
+		if err != nil {
+			fmt.Fprintf(out, "Could not retrieve list of installed SSH-Keys: %v\n", err)
+			return
+		}
+		for _, key := range keys {
+			if vmName, ephemeral := ssh.IsEphemeralKey(key.Name); ephemeral && easyvpn.IsEasyVpn(vmName) && vmName != EASYVPN_IDENTIFIER {
+				names = append(names, vmName)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if existing[name] {
+			continue
+		}
+		if err := ssh.DeleteEphemeralKey(p, name); err != nil {
+			fmt.Fprintf(out, "Could not delete ephemeral SSH-Key of [%s]: %v\n", name, err)
+		}
+	}
+}
+
+// destroyAll destroys all given virtual machines, after asking for confirmation unless yes is set,
+// declined tells if the user did not confirm
+func destroyAll(c *cli.Context, targets []destroyTarget, yes bool) (doc downDocument, declined bool) {
+	doc.Destroyed = []destroyDocument{}
+	doc.Failed = []destroyDocument{}
+
+	out := messages(c)
+	if len(targets) == 0 {
+		fmt.Fprintln(out, "Virtual machine did not exist")
+		return doc, false
+	}
+
+	if !yes {
+		fmt.Fprintln(out, "Do you really want to destroy the following virtual machines?")
+		for _, target := range targets {
+			fmt.Fprintf(out, "%s: %q\n", target.Provider.GetProviderName(), target.VM)
+		}
+		if !confirm(c) {
+			return doc, true
+		}
+	}
+
+	for _, target := range targets {
+		fmt.Fprintf(out, "Destroy virtual machine [%s]\n", target.VM.Name)
+
+		result := destroyDocument{
+			VM:       newVmDocument(target.VM),
+			Provider: target.Provider.GetProviderName(),
+		}
+		if err := disconnectFrom(c, target.VM.IP); err != nil {
+			fmt.Fprintf(out, "Could not disconnect from VPN [%s]: %v\n", target.VM.IP, err)
+		}
+		if err := easyvpn.New(target.Provider).Destroy(context.Background(), target.VM); err != nil {
+			result.Error = err.Error()
+			doc.Failed = append(doc.Failed, result)
+			continue
+		}
+		doc.Destroyed = append(doc.Destroyed, result)
+	}
+	return doc, false
+}
+
+// printDownSummary reports what was destroyed and what failed, and exits non-zero if anything failed
+func printDownSummary(c *cli.Context, doc downDocument) {
+	if isStructuredOutput(c) {
+		printDocument(c, doc)
+	} else if len(doc.Destroyed)+len(doc.Failed) > 0 {
+		fmt.Println("=========================================================================")
+		for _, result := range doc.Destroyed {
+			fmt.Fprintf(writer, "Destroyed: %s\tProvider: %s\tId: %s\n", result.VM.Name, result.Provider, result.VM.Id)
+		}
+		for _, result := range doc.Failed {
+			fmt.Fprintf(writer, "Failed: %s\tProvider: %s\tId: %s\tError: %s\n",
+				result.VM.Name, result.Provider, result.VM.Id, strings.TrimSpace(result.Error))
+		}
+		writer.Flush()
+		fmt.Println("=========================================================================")
+	}
+
+	if len(doc.Failed) > 0 {
+		os.Exit(1)
+	}
+}
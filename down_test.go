@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyProvider records the ssh-keys deleted
+type keyProvider struct {
+	test.MockProvider
+	deleted *[]string
+}
+
+func (p keyProvider) DeleteSshKey(id string) error {
+	*p.deleted = append(*p.deleted, id)
+	return nil
+}
+
+func Test_Down_SelectMachines(t *testing.T) {
+	now := time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC)
+	machines := []provider.VM{
+		provider.VM{Id: "1", Name: "easy-vpn", Created: now.Add(-3 * time.Hour)},
+		provider.VM{Id: "2", Name: "easy-vpn-ams3", Created: now.Add(-1 * time.Hour)},
+		provider.VM{Id: "3", Name: "easy-vpn-sgp1"},
+		provider.VM{Id: "4", Name: "mockName", Created: now.Add(-5 * time.Hour)},
+	}
+
+	selected := selectMachines(machines, false, 0, now)
+	if assert.Equal(t, 1, len(selected)) {
+		assert.Equal(t, "1", selected[0].Id)
+	}
+
+	selected = selectMachines(machines, true, 0, now)
+	if assert.Equal(t, 3, len(selected)) {
+		assert.Equal(t, "1", selected[0].Id)
+		assert.Equal(t, "2", selected[1].Id)
+		assert.Equal(t, "3", selected[2].Id)
+	}
+
+	selected = selectMachines(machines, true, 2*time.Hour, now)
+	if assert.Equal(t, 1, len(selected)) {
+		assert.Equal(t, "1", selected[0].Id)
+	}
+
+	selected = selectMachines(machines, false, 4*time.Hour, now)
+	assert.Equal(t, 0, len(selected))
+}
+
+func Test_Down_DeleteLeftoverKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyDir := filepath.Join(dir, "keys")
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"easy-vpn", "easy-vpn-ams3", "easy-vpn-sgp1"} {
+		if err := ioutil.WriteFile(filepath.Join(keyDir, name), []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deleted []string
+	p := keyProvider{
+		MockProvider: test.MockProvider{
+			Config: &config.Config{StateDir: dir},
+			Keys: []provider.SshKey{
+				{Id: "k1", Name: "easy-vpn.ephemeral"},
+				{Id: "k2", Name: "easy-vpn-ams3.ephemeral"},
+				{Id: "k3", Name: "easy-vpn-sgp1.ephemeral"},
+				{Id: "k4", Name: "mockName.ephemeral"},
+			},
+		},
+		deleted: &deleted,
+	}
+	machines := []provider.VM{provider.VM{Id: "1", Name: "easy-vpn-sgp1"}}
+
+	// the single vm is gone, e.g. self-destructed
+	deleteLeftoverKeys(ioutil.Discard, p, machines, false)
+	assert.Equal(t, []string{"k1"}, deleted)
+	_, err = os.Stat(filepath.Join(keyDir, "easy-vpn"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(keyDir, "easy-vpn-ams3"))
+	assert.Nil(t, err)
+
+	// all of them, but the key of an existing vm is left to its destruction
+	deleted = nil
+	deleteLeftoverKeys(ioutil.Discard, p, machines, true)
+	assert.Equal(t, []string{"k1", "k2"}, deleted)
+	_, err = os.Stat(filepath.Join(keyDir, "easy-vpn-ams3"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(keyDir, "easy-vpn-sgp1"))
+	assert.Nil(t, err)
+}
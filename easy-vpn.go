@@ -1,27 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/JamesClonk/easy-vpn/client"
 	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/easyvpn"
 	"github.com/JamesClonk/easy-vpn/provider"
 	"github.com/JamesClonk/easy-vpn/provider/digitalocean"
 	"github.com/JamesClonk/easy-vpn/provider/vultr"
-	"github.com/JamesClonk/easy-vpn/rng"
-	"github.com/JamesClonk/easy-vpn/ssh"
 	"github.com/JamesClonk/easy-vpn/vm"
 	"github.com/codegangsta/cli"
 )
 
 const (
 	VERSION            = "1.0.0"
-	EASYVPN_IDENTIFIER = "easy-vpn"
+	EASYVPN_IDENTIFIER = easyvpn.IDENTIFIER
 )
 
 var (
@@ -66,6 +68,11 @@ func main() {
 			Value: "360",
 			Usage: "maximum uptime in minutes after which the VPS will self-destruct",
 		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Value: "table",
+			Usage: "output format of show, status, up and credentials: table, json or yaml",
+		},
 	}
 
 	app.Commands = []cli.Command{{
@@ -78,6 +85,10 @@ func main() {
 				Name:  "region, r",
 				Usage: "specify which region to use for new VPS",
 			},
+			cli.BoolFlag{
+				Name:  "rollback-on-failure",
+				Usage: "destroy the newly created virtual machine again if provisioning fails or is interrupted",
+			},
 		},
 		Action: func(c *cli.Context) {
 			startVpn(c)
@@ -87,9 +98,233 @@ func main() {
 		ShortName:   "d",
 		Usage:       "Shutdown and destroy",
 		Description: "Destroys/deletes the easy-vpn virtual machine if it exists.",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "yes, y",
+				Usage: "do not ask for confirmation",
+			},
+			cli.BoolFlag{
+				Name:  "all",
+				Usage: "destroy all easy-vpn virtual machines, including the fleet, across all configured providers",
+			},
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "only destroy virtual machines created longer ago than this, e.g. 2h or 90m",
+			},
+		},
 		Action: func(c *cli.Context) {
 			destroyVpn(c)
 		},
+	}, {
+		Name:        "status",
+		ShortName:   "st",
+		Usage:       "Show status of easy-vpn vm's",
+		Description: "Reports remaining lifetime, VPN health, connected clients and load of all easy-vpn virtual machines.",
+		Action: func(c *cli.Context) {
+			statusVpn(c)
+		},
+	}, {
+		Name:        "gc",
+		Usage:       "Clean up orphaned SSH-Keys and stray vm's",
+		Description: "Finds easy-vpn SSH-Keys no longer used by any virtual machine, and virtual machines past their self-destruct deadline or whose provisioning never completed, then deletes them.",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "only list what would be deleted",
+			},
+			cli.BoolFlag{
+				Name:  "yes, y",
+				Usage: "do not ask for confirmation",
+			},
+			cli.StringFlag{
+				Name:  "grace",
+				Value: "1h",
+				Usage: "ignore virtual machines younger than this, they might still be provisioning",
+			},
+		},
+		Action: func(c *cli.Context) {
+			collectGarbage(c)
+		},
+	}, {
+		Name:        "fleet",
+		ShortName:   "f",
+		Usage:       "Spin up or destroy vm's in several regions",
+		Description: "Manages a fleet of easy-vpn virtual machines, one per region, all of them provisioned in parallel.",
+		Subcommands: []cli.Command{{
+			Name:        "up",
+			Usage:       "Spin up new vm's",
+			Description: "Creates a new easy-vpn virtual machine with a docker-pptpd container in each of the given regions.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "regions, r",
+					Usage: "comma separated list of regions to use for new VPS, e.g. nyc3,ams3,sgp1",
+				},
+				cli.BoolFlag{
+					Name:  "rollback-on-failure",
+					Usage: "destroy the newly created virtual machine again if provisioning fails or is interrupted",
+				},
+			},
+			Action: func(c *cli.Context) {
+				fleetUp(c)
+			},
+		}, {
+			Name:        "down",
+			Usage:       "Shutdown and destroy",
+			Description: "Destroys/deletes all easy-vpn fleet virtual machines, or only those of the given regions.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "regions, r",
+					Usage: "comma separated list of regions to destroy, defaults to all",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation",
+				},
+			},
+			Action: func(c *cli.Context) {
+				fleetDown(c)
+			},
+		}},
+	}, {
+		Name:        "credentials",
+		ShortName:   "c",
+		Usage:       "Show VPN username and password",
+		Description: "Reads the username and password of the running VPN server back from the easy-vpn virtual machine.",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "rotate",
+				Usage: "generate a new password first, disconnecting the sessions using the old one",
+			},
+		},
+		Action: func(c *cli.Context) {
+			showCredentials(c)
+		},
+	}, {
+		Name:        "connect",
+		Usage:       "Connect to the VPN",
+		Description: "Connects this machine to the VPN server of the easy-vpn virtual machine, routing all traffic through it.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "connector",
+				Usage: "VPN client to use: pptpsetup, nmcli, wg-quick or custom, detected if not given",
+			},
+			cli.BoolFlag{
+				Name:  "skip-verify",
+				Usage: "do not verify that traffic actually goes through the VPN",
+			},
+			cli.BoolFlag{
+				Name:  "kill-switch",
+				Usage: "drop all traffic outside the VPN until disconnect, even if kill_switch is not configured",
+			},
+			cli.BoolFlag{
+				Name:  "watch",
+				Usage: "stay in the foreground, reconnecting whenever the VPN breaks down and re-creating the VPS if it is gone",
+			},
+			cli.IntFlag{
+				Name:  "interval",
+				Value: 10,
+				Usage: "seconds between health checks of the VPN connection, with --watch",
+			},
+			cli.BoolFlag{
+				Name:  "yes, y",
+				Usage: "with --watch and the kill switch, drop it to re-create the VPS if it is gone",
+			},
+		},
+		Action: func(c *cli.Context) {
+			connectVpn(c)
+		},
+	}, {
+		Name:        "disconnect",
+		Usage:       "Disconnect from the VPN",
+		Description: "Disconnects this machine from the VPN and restores its previous routes and DNS configuration.",
+		Action: func(c *cli.Context) {
+			disconnectVpn(c)
+		},
+	}, {
+		Name:        "recover",
+		Usage:       "Restore networking after easy-vpn died while connected",
+		Description: "Removes the kill switch rules, tears down any leftover VPN connection and restores the routes and DNS configuration from before.",
+		Action: func(c *cli.Context) {
+			recoverNetwork(c)
+		},
+	}, {
+		Name:        "export",
+		Usage:       "Export a VPN client profile",
+		Description: "Renders an importable client profile for the VPN server, for NetworkManager (nm), Windows (rasphone) or Apple devices (mobileconfig).",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format, f",
+				Usage: "nm, rasphone or mobileconfig",
+			},
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "file to write the profile to, instead of stdout",
+			},
+			cli.StringFlag{
+				Name:  "user",
+				Usage: "VPN user to export the profile of, instead of the first one",
+			},
+			cli.BoolFlag{
+				Name:  "qr",
+				Usage: "show the profile as QR code on the terminal, for formats imported by mobile devices",
+			},
+		},
+		Action: func(c *cli.Context) {
+			exportProfile(c)
+		},
+	}, {
+		Name:        "users",
+		Usage:       "Manage VPN users",
+		Description: "Shares the easy-vpn virtual machine among several VPN users, each with their own revocable credentials.",
+		Subcommands: []cli.Command{{
+			Name:        "list",
+			Usage:       "List VPN users",
+			Description: "Lists all VPN users of the easy-vpn virtual machine.",
+			Action: func(c *cli.Context) {
+				listUsers(c)
+			},
+		}, {
+			Name:        "add",
+			Usage:       "Add a VPN user",
+			Description: "Adds a VPN user with a generated password, and a generated username unless one is given as argument.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out-dir",
+					Usage: "write the credentials into <out-dir>/<username>.txt instead of printing them",
+				},
+				cli.StringFlag{
+					Name:  "encrypt-to",
+					Usage: "encrypt the credentials file to an age public key (age1...) or a GPG key id",
+				},
+			},
+			Action: func(c *cli.Context) {
+				addUser(c)
+			},
+		}, {
+			Name:        "remove",
+			Usage:       "Remove a VPN user",
+			Description: "Revokes the credentials of the VPN user given as argument and disconnects its sessions.",
+			Action: func(c *cli.Context) {
+				removeUser(c)
+			},
+		}, {
+			Name:        "rotate",
+			Usage:       "Rotate the password of a VPN user",
+			Description: "Generates a new password for the VPN user given as argument and disconnects its sessions.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out-dir",
+					Usage: "write the credentials into <out-dir>/<username>.txt instead of printing them",
+				},
+				cli.StringFlag{
+					Name:  "encrypt-to",
+					Usage: "encrypt the credentials file to an age public key (age1...) or a GPG key id",
+				},
+			},
+			Action: func(c *cli.Context) {
+				rotateUser(c)
+			},
+		}},
 	}, {
 		Name:        "show",
 		ShortName:   "s",
@@ -108,77 +343,69 @@ func main() {
 }
 
 func startVpn(c *cli.Context) {
-	p := getProvider(c)
+	e := getEngine(c)
+	e.Progress = vm.Progress{Writer: messages(c)}
+	e.AdminIPs = adminIPs(c)
 
-	sshkeyId := ssh.GetEasyVpnKeyId(p, EASYVPN_IDENTIFIER)
-	machine := vm.GetEasyVpn(p, sshkeyId, EASYVPN_IDENTIFIER)
+	ctx, cancel := interruptible(c)
+	defer cancel()
 
-	fmt.Println("=========================================================================")
-	fmt.Fprintf(writer, "Id: %s\tName: %s\tIP: %s\n", machine.Id, machine.Name, machine.IP)
-	fmt.Fprintf(writer, "OS: %s\tRegion: %s\tStatus: %s\n", machine.OS, machine.Region, machine.Status)
-	writer.Flush()
-	fmt.Println("=========================================================================\n")
+	deployment, err := e.Up(ctx)
+	if perr, ok := err.(*easyvpn.ProvisionError); ok && perr.Created {
+		rollback(c, e, perr.VM, ctx.Err() != nil)
+	}
+	alreadyRunning := err == easyvpn.ErrAlreadyRunning
+	if alreadyRunning {
+		e.Progress.Println("pptpd is already running on virtual machine")
+		deployment, err = e.Credentials(ctx, false)
+	}
+	if err != nil {
+		fail(c, err)
+	}
+	machine := deployment.VM
 
-	// check if docker pptpd is already running
-	out := ssh.Exec(p, machine.IP, `ps -ef | grep pptpd | grep -v grep; echo "..."`)
-	if strings.Contains(out, "pptpd") {
-		fmt.Println("pptpd is already running on virtual machine")
-		fmt.Println("Please use previously generated username and password to setup pptp connection")
-		os.Exit(1)
+	if isStructuredOutput(c) {
+		printDocument(c, newUpDocument(deployment))
 	} else {
-		// update machine
-		fmt.Println("Update virtual machine")
-		ssh.Call(p, machine.IP, `apt-get update -qq`)
-		ssh.Call(p, machine.IP, `apt-get install -qy docker.io pptpd iptables curl at`)
-		ssh.Exec(p, machine.IP, `service pptpd stop`)
-
-		// setup self-destruct
-		fmt.Println("Setup self-destruct mechanism for virtual machine")
-		ssh.WriteSelfDestruct(p, machine.IP, p.GetConfig().SelfDestructFile)
-		ssh.Call(p, machine.IP, // abuse at for background task
-			fmt.Sprintf(`echo "/bin/bash /root/self-destruct.sh %s %s %s %d" | at now`,
-				p.GetConfig().Provider,
-				p.GetConfig().Providers[p.GetConfig().Provider].ApiKey,
-				machine.Id, p.GetConfig().Options.Uptime*60))
-
-		// generate username & password for pptpd
-		username := rng.GenerateUsername()
-		password := rng.GeneratePassword()
-
-		// setup docker
-		fmt.Println("Setup docker on virtual machine")
-		ssh.Call(p, machine.IP, `service docker.io restart`)
-		ssh.Call(p, machine.IP, `docker pull jamesclonk/docker-pptpd`)
-		ssh.Exec(p, machine.IP, fmt.Sprintf(`echo "%s * %s *" > /chap-secrets`, username, password))
-
-		// run docker
-		fmt.Println("Run docker-pptpd container on virtual machine")
-		ssh.Call(p, machine.IP, `docker run --name pptpd --privileged -d -p 1723:1723 -v /chap-secrets:/etc/ppp/chap-secrets:ro jamesclonk/docker-pptpd`)
-
-		log.Printf("docker-pptpd started, with username [%s] and password [%s]\n", username, password)
-
-		// connect to vpn server if autoconnect option is on
-		if p.GetConfig().Options.Autoconnect {
-			fmt.Println("Connect to VPN")
-			connect(
-				p.GetConfig().Options.ConnectCmd,
-				machine.IP,
-				username,
-				password,
-			)
+		printMachine(machine)
+		if alreadyRunning {
+			log.Printf("docker-pptpd is running, with username [%s] and password [%s]\n", deployment.Username, deployment.Password)
+		} else {
+			log.Printf("docker-pptpd started, with username [%s] and password [%s]\n", deployment.Username, deployment.Password)
 		}
 	}
 
+	// connect to vpn server if autoconnect option is on
+	if e.Provider.GetConfig().Options.Autoconnect {
+		e.Progress.Println("Connect to VPN")
+		connectTo(c, deployment, "")
+	}
 }
 
-func destroyVpn(c *cli.Context) {
-	p := getProvider(c)
-	vm.DestroyEasyVpn(p, EASYVPN_IDENTIFIER)
+func printMachine(machine provider.VM) {
+	fmt.Println("=========================================================================")
+	fmt.Fprintf(writer, "Id: %s\tName: %s\tIP: %s\n", machine.Id, machine.Name, machine.IP)
+	fmt.Fprintf(writer, "OS: %s\tRegion: %s\tStatus: %s\n", machine.OS, machine.Region, machine.Status)
+	writer.Flush()
+	fmt.Print("=========================================================================\n\n")
 }
 
 func showVpn(c *cli.Context) {
-	p := getProvider(c)
-	for _, machine := range vm.GetAll(p) {
+	machines, err := getEngine(c).Show(context.Background())
+	if err != nil {
+		fail(c, err)
+	}
+
+	if isStructuredOutput(c) {
+		doc := showDocument{VMs: []vmDocument{}}
+		for _, machine := range machines {
+			doc.VMs = append(doc.VMs, newVmDocument(machine))
+		}
+		printDocument(c, doc)
+		return
+	}
+
+	for _, machine := range machines {
 		fmt.Println("=========================================================================")
 		fmt.Fprintf(writer, "Id: %s\tName: %s\tIP: %s\n", machine.Id, machine.Name, machine.IP)
 		fmt.Fprintf(writer, "OS: %s\tRegion: %s\tStatus: %s\n", machine.OS, machine.Region, machine.Status)
@@ -187,39 +414,29 @@ func showVpn(c *cli.Context) {
 	fmt.Println("=========================================================================")
 }
 
-func connect(commands [][]string, ip, username, password string) {
-	commands = replaceCommandVariables(commands, ip, username, password)
-
-	for _, command := range commands {
-		out, err := exec.Command(command[0], command[1:]...).CombinedOutput()
-		if err != nil {
-			log.Println(string(out))
-			log.Fatal(err)
-		}
-		fmt.Println(string(out))
-	}
-}
+// confirm asks the user to confirm a destructive action by typing "YES",
+// the prompt goes to stderr with structured output, to keep stdout clean for the document
+func confirm(c *cli.Context) bool {
+	fmt.Fprint(messages(c), `Confirm with "YES": `)
 
-func replaceCommandVariables(commands [][]string, ip, username, password string) [][]string {
-	result := make([][]string, len(commands))
-	for i, command := range commands {
-		cmd := make([]string, len(command))
-		for j, arg := range command {
-			arg = strings.Replace(arg, "$IP", ip, -1)
-			arg = strings.Replace(arg, "$USER", username, -1)
-			arg = strings.Replace(arg, "$PASS", password, -1)
-			cmd[j] = arg
-		}
-		result[i] = cmd
+	reader := bufio.NewReader(os.Stdin)
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	return result
+	return strings.Trim(answer, "\t\n\r ") == "YES"
 }
 
 func parseGlobalOptions(c *cli.Context) *config.Config {
 	cfg, err := config.LoadConfiguration(c.GlobalString("config"))
 	if err != nil {
-		log.Fatal(err)
+		fail(c, err)
+	}
+
+	switch outputFormat(c) {
+	case OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_YAML:
+	default:
+		fail(c, fmt.Errorf("Invalid value for --output option given: %v", c.GlobalString("output")))
 	}
 
 	if c.GlobalIsSet("provider") {
@@ -242,7 +459,7 @@ func parseGlobalOptions(c *cli.Context) *config.Config {
 	if c.GlobalIsSet("uptime") {
 		uptime, err := strconv.ParseInt(c.GlobalString("uptime"), 10, 32)
 		if err != nil {
-			log.Fatalf("Invalid value for --uptime option given: %v\n", c.GlobalString("uptime"))
+			fail(c, fmt.Errorf("Invalid value for --uptime option given: %v", c.GlobalString("uptime")))
 		}
 		cfg.Options.Uptime = int(uptime)
 	}
@@ -259,18 +476,39 @@ func parseGlobalOptions(c *cli.Context) *config.Config {
 	return cfg
 }
 
+// adminIPs is the public IP address of this machine, the only one allowed to SSH into new virtual machines
+func adminIPs(c *cli.Context) []string {
+	ip, _, err := client.EgressIP(parseGlobalOptions(c).Options.VerifyURL)
+	if err != nil {
+		fmt.Fprintf(messages(c), "Warning: %v\n", err)
+		return nil
+	}
+	if strings.Contains(ip, ":") {
+		return []string{ip + "/128"}
+	}
+	return []string{ip + "/32"}
+}
+
+func getEngine(c *cli.Context) *easyvpn.Engine {
+	return easyvpn.New(getProvider(c))
+}
+
 func getProvider(c *cli.Context) provider.API {
-	cfg := parseGlobalOptions(c)
+	p, err := newProvider(parseGlobalOptions(c))
+	if err != nil {
+		fail(c, err)
+	}
+	return p
+}
 
+func newProvider(cfg *config.Config) (provider.API, error) {
 	switch cfg.Provider {
 	case "digitalocean":
-		return digitalocean.DO{Config: cfg}
+		return digitalocean.DO{Config: cfg}, nil
 	case "vultr":
-		return vultr.Vultr{Config: cfg}
+		return vultr.Vultr{Config: cfg}, nil
 	case "aws":
-		log.Fatal("Not yet implemented!")
-	default:
-		log.Fatal("Unknown provider!")
+		return nil, errors.New("Not yet implemented!")
 	}
-	return nil
+	return nil, errors.New("Unknown provider!")
 }
@@ -8,26 +8,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_Main_Connect(t *testing.T) {
-	connect([][]string{[]string{"echo", "hello world!"}}, "123.456.789", "testuser", "testpassword")
-}
-
-func Test_Main_ReplaceCommandVariables(t *testing.T) {
-	result := replaceCommandVariables(
-		[][]string{[]string{"connect", ";$IP;", ":$USER:", " $PASS "}, []string{"disconnect"}},
-		"123.456.789",
-		"testuser",
-		"testpassword")
-	if assert.NotNil(t, result) {
-		assert.Equal(t, 2, len(result))
-		assert.Equal(t, "connect", result[0][0])
-		assert.Equal(t, ";123.456.789;", result[0][1])
-		assert.Equal(t, ":testuser:", result[0][2])
-		assert.Equal(t, " testpassword ", result[0][3])
-		assert.Equal(t, "disconnect", result[1][0])
-	}
-}
-
 func Test_Main_ParseGlobalOptions(t *testing.T) {
 	set := flag.NewFlagSet("test", 0)
 	set.String("config", "fixtures/config_test.toml", "...")
@@ -0,0 +1,119 @@
+package easyvpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+)
+
+// STEPS are the provisioning steps of Up in order, each of them can safely be run again
+// and is recorded on the virtual machine once completed, so an interrupted Up can resume where it stopped
+var STEPS = []string{"update", "self-destruct", "credentials", "docker", "pptpd", "sshd", "firewall"}
+
+const stepsFile = "/root/easy-vpn.steps"
+
+// checkpointCmd reads the provisioning state of a virtual machine in one SSH roundtrip
+const checkpointCmd = `sed 's/^/step=/' /root/easy-vpn.steps 2>/dev/null
+[ "$(docker inspect -f '{{.State.Running}}' pptpd 2>/dev/null)" = "true" ] && echo "pptpd=running"
+echo "deadline=$(cat /root/self-destruct.deadline 2>/dev/null)"
+echo "secrets=$(head -n 1 /chap-secrets 2>/dev/null)"
+echo "..."`
+
+// checkpoint is the provisioning state of a virtual machine
+type checkpoint struct {
+	Steps    map[string]bool
+	Running  bool // is the pptpd container running
+	Username string
+	Password string
+	Deadline time.Time
+}
+
+func parseCheckpoint(out string) checkpoint {
+	cp := checkpoint{Steps: make(map[string]bool)}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], strings.TrimSpace(parts[1])
+
+		switch key {
+		case "step":
+			cp.Steps[value] = true
+		case "pptpd":
+			cp.Running = value == "running"
+		case "deadline":
+			if deadline, err := strconv.ParseInt(value, 10, 64); err == nil {
+				cp.Deadline = time.Unix(deadline, 0)
+			}
+		case "secrets":
+			cp.Username, cp.Password = parseSecrets(value)
+		}
+	}
+	return cp
+}
+
+// parseSecrets reads username and password from a chap-secrets line: "<username> * <password> *"
+func parseSecrets(line string) (username, password string) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return "", ""
+	}
+	return fields[0], fields[2]
+}
+
+// done tells if a step does not need to be run again. Machines provisioned before steps were recorded
+// count as completed if pptpd is running, and the pptpd step is only done as long as pptpd is actually running.
+func (cp checkpoint) done(step string) bool {
+	if len(cp.Steps) == 0 {
+		return cp.Running
+	}
+	if step == "pptpd" && !cp.Running {
+		return false
+	}
+	return cp.Steps[step]
+}
+
+func (cp checkpoint) completed() bool {
+	for _, step := range STEPS {
+		if !cp.done(step) {
+			return false
+		}
+	}
+	return true
+}
+
+// record writes the completed steps of a virtual machine into the local state directory
+func record(dir string, machine provider.VM, steps []string) error {
+	dir, err := config.ExpandHome(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Could not create state directory: %s\n%v", dir, err)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Id      string    `json:"id"`
+		Name    string    `json:"name"`
+		Steps   []string  `json:"steps"`
+		Updated time.Time `json:"updated"`
+	}{machine.Id, machine.Name, steps, time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, fmt.Sprintf("%s-%s.json", machine.Name, machine.Id))
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("Could not write file: %s\n%v", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package easyvpn
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EasyVpn_ParseCheckpoint(t *testing.T) {
+	cp := parseCheckpoint(`step=update
+step=self-destruct
+step=credentials
+deadline=1420070400
+secrets=jdoe * s3cr3t *
+...`)
+
+	assert.True(t, cp.done("update"))
+	assert.True(t, cp.done("self-destruct"))
+	assert.True(t, cp.done("credentials"))
+	assert.False(t, cp.done("docker"))
+	assert.False(t, cp.done("pptpd"))
+	assert.False(t, cp.completed())
+	assert.Equal(t, "jdoe", cp.Username)
+	assert.Equal(t, "s3cr3t", cp.Password)
+	assert.Equal(t, time.Unix(1420070400, 0), cp.Deadline)
+}
+
+func Test_EasyVpn_ParseCheckpoint_Completed(t *testing.T) {
+	out := `step=update
+step=self-destruct
+step=credentials
+step=docker
+step=pptpd
+step=sshd
+step=firewall
+deadline=
+secrets=
+...`
+
+	// pptpd has to be actually running
+	cp := parseCheckpoint(out)
+	assert.False(t, cp.done("pptpd"))
+	assert.False(t, cp.completed())
+	assert.Equal(t, "", cp.Username)
+	assert.True(t, cp.Deadline.IsZero())
+
+	cp = parseCheckpoint("pptpd=running\n" + out)
+	assert.True(t, cp.completed())
+}
+
+func Test_EasyVpn_ParseCheckpoint_Legacy(t *testing.T) {
+	// machines provisioned before steps were recorded
+	cp := parseCheckpoint("pptpd=running\nsecrets=jdoe * s3cr3t *\n...")
+	assert.True(t, cp.completed())
+	assert.Equal(t, "jdoe", cp.Username)
+
+	cp = parseCheckpoint("deadline=\nsecrets=\n...")
+	assert.False(t, cp.done("update"))
+	assert.False(t, cp.completed())
+}
+
+func Test_EasyVpn_Record(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	machine := provider.VM{Id: "mockId", Name: "easy-vpn"}
+	assert.Nil(t, record(filepath.Join(dir, "state"), machine, []string{"update", "self-destruct"}))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "state", "easy-vpn-mockId.json"))
+	if assert.Nil(t, err) {
+		var state struct {
+			Id    string   `json:"id"`
+			Steps []string `json:"steps"`
+		}
+		assert.Nil(t, json.Unmarshal(data, &state))
+		assert.Equal(t, "mockId", state.Id)
+		assert.Equal(t, []string{"update", "self-destruct"}, state.Steps)
+	}
+}
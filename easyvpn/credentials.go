@@ -0,0 +1,43 @@
+package easyvpn
+
+import (
+	"context"
+)
+
+// Credentials reads the username and password of the running VPN server back from the virtual machine,
+// which are those of its first VPN user. With rotate set a new password is generated for this user first,
+// and its sessions are disconnected.
+func (e *Engine) Credentials(ctx context.Context, rotate bool) (*Deployment, error) {
+	defer e.hangup()
+
+	machine, err := e.machine(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := e.run(ctx, machine, checkpointCmd)
+	if err != nil {
+		return nil, err
+	}
+	cp := parseCheckpoint(out)
+	if len(cp.Username) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	deployment := &Deployment{
+		VM:       machine,
+		Protocol: PROTOCOL,
+		Port:     PORT,
+		Username: cp.Username,
+		Password: cp.Password,
+		Deadline: cp.Deadline,
+	}
+	if rotate {
+		user, err := e.RotateUser(ctx, cp.Username)
+		if err != nil {
+			return nil, err
+		}
+		deployment.Password = user.Password
+	}
+	return deployment, nil
+}
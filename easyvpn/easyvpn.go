@@ -0,0 +1,389 @@
+package easyvpn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/rng"
+	"github.com/JamesClonk/easy-vpn/ssh"
+	"github.com/JamesClonk/easy-vpn/vm"
+)
+
+// IDENTIFIER is the name of the easy-vpn virtual machine and ssh-key, fleet virtual machines use it as prefix
+const IDENTIFIER = "easy-vpn"
+
+// PROTOCOL and PORT are what the VPN server of docker-pptpd speaks
+const (
+	PROTOCOL = "pptp"
+	PORT     = 1723
+)
+
+// Engine spins up, inspects and destroys an easy-vpn virtual machine on a cloud VPS provider
+type Engine struct {
+	Provider provider.API
+	Name     string   // name of the virtual machine, defaults to IDENTIFIER
+	SshKeyId string   // id of an already installed ssh-key, Up installs the easy-vpn ssh-key if empty
+	StateDir string   // local directory to record completed provisioning steps in, nothing is recorded if empty
+	AdminIPs []string // CIDRs allowed to SSH into the virtual machine besides the configured ssh_allow ones, anybody if both are empty
+	Progress vm.Progress
+
+	lock    sync.Mutex
+	clients map[string]*ssh.Client // by IP address
+}
+
+// Deployment describes a running VPN server
+type Deployment struct {
+	VM       provider.VM
+	Protocol string
+	Port     int
+	Username string
+	Password string
+	Deadline time.Time
+}
+
+func New(p provider.API) *Engine {
+	return &Engine{
+		Provider: p,
+		Name:     IDENTIFIER,
+		StateDir: p.GetConfig().GetStateDir(),
+		Progress: vm.Stdout,
+	}
+}
+
+// IsEasyVpn checks if a virtual machine is managed by easy-vpn, either the single one or one of a fleet
+func IsEasyVpn(name string) bool {
+	return name == IDENTIFIER || strings.HasPrefix(name, IDENTIFIER+"-")
+}
+
+// Up creates the virtual machine if necessary and installs a VPN server on it, resuming at the first
+// provisioning step that has not been completed yet if the machine already exists.
+// If all steps are completed and pptpd is running it returns ErrAlreadyRunning, together with the existing Deployment.
+func (e *Engine) Up(ctx context.Context) (*Deployment, error) {
+	defer e.hangup()
+
+	sshkeyId := e.SshKeyId
+	if len(sshkeyId) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, &ProvisionError{Step: "ssh-key", Err: err}
+		}
+
+		var err error
+		if e.Provider.GetConfig().SshEphemeralKey {
+			sshkeyId, err = ssh.EphemeralKeyId(e.Provider.WithContext(ctx), e.name())
+		} else {
+			sshkeyId, err = ssh.EasyVpnKeyId(e.Provider.WithContext(ctx), IDENTIFIER)
+		}
+		if err != nil {
+			return nil, &ProvisionError{Step: "ssh-key", Err: err}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, &ProvisionError{Step: "vm", Err: err}
+	}
+	machine, created, err := vm.Get(ctx, e.Provider, sshkeyId, e.name(), e.Progress)
+	if err != nil {
+		return nil, &ProvisionError{Step: "vm", VM: machine, Created: created, Err: err}
+	}
+	if err := e.cloudFirewall(ctx, machine, false); err != nil {
+		return &Deployment{VM: machine}, &ProvisionError{Step: "cloud-firewall", VM: machine, Created: created, Err: err}
+	}
+
+	// find out how far provisioning got already
+	out, err := e.run(ctx, machine, checkpointCmd)
+	if err != nil {
+		return &Deployment{VM: machine}, &ProvisionError{Step: "check", VM: machine, Created: created, Err: err}
+	}
+	cp := parseCheckpoint(out)
+	deployment := &Deployment{
+		VM:       machine,
+		Protocol: PROTOCOL,
+		Port:     PORT,
+		Username: cp.Username,
+		Password: cp.Password,
+		Deadline: cp.Deadline,
+	}
+	if cp.completed() {
+		return deployment, ErrAlreadyRunning
+	}
+
+	if err := e.install(ctx, deployment, cp); err != nil {
+		err.Created = created
+		return deployment, err
+	}
+	return deployment, nil
+}
+
+// Down destroys the virtual machine of the Engine, returning ErrNotFound if it does not exist
+func (e *Engine) Down(ctx context.Context) (provider.VM, error) {
+	machine, exists, err := vm.Find(e.Provider.WithContext(ctx), e.name())
+	if err != nil {
+		return machine, &ProviderError{Op: "retrieve list of virtual machines", Err: err}
+	}
+	if !exists {
+		// the machine might have self-destructed, leaving its ephemeral ssh-key behind
+		if err := ssh.DeleteEphemeralKey(e.Provider.WithContext(ctx), e.name()); err != nil {
+			e.Progress.Println(err)
+		}
+		return machine, ErrNotFound
+	}
+	return machine, e.Destroy(ctx, machine)
+}
+
+// Destroy destroys any given virtual machine
+func (e *Engine) Destroy(ctx context.Context, machine provider.VM) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.Provider.WithContext(ctx).DestroyVM(machine.Id); err != nil {
+		return &ProviderError{Op: fmt.Sprintf("destroy virtual machine [%s]", machine.Name), Err: err}
+	}
+	// the virtual machine is gone anyway, a leftover firewall is deleted by the next Up
+	if err := e.deleteCloudFirewall(ctx, machine); err != nil {
+		e.Progress.Println(err)
+	}
+	if len(machine.IP) > 0 {
+		if err := ssh.Forget(e.Provider.GetConfig(), machine.IP); err != nil {
+			e.Progress.Println(err)
+		}
+	}
+	if len(machine.Name) > 0 {
+		if err := ssh.DeleteEphemeralKey(e.Provider.WithContext(ctx), machine.Name); err != nil {
+			e.Progress.Println(err)
+		}
+	}
+	return nil
+}
+
+// Show returns all virtual machines of the provider account, not only those managed by easy-vpn
+func (e *Engine) Show(ctx context.Context) ([]provider.VM, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	machines, err := e.Provider.WithContext(ctx).GetAllVMs()
+	if err != nil {
+		return nil, &ProviderError{Op: "retrieve list of virtual machines", Err: err}
+	}
+	return machines, nil
+}
+
+// Status inspects all easy-vpn virtual machines. Machines that are not active or could not be inspected
+// are included as well, the latter with Status.Err set.
+func (e *Engine) Status(ctx context.Context) ([]Status, error) {
+	defer e.hangup()
+
+	machines, err := e.Show(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, machine := range machines {
+		if !IsEasyVpn(machine.Name) {
+			continue
+		}
+
+		status := Status{VM: machine}
+		if machine.Status == "active" {
+			if err := ctx.Err(); err != nil {
+				return statuses, err
+			}
+			status, status.Err = e.StatusOf(ctx, machine)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// StatusOf inspects a single virtual machine through SSH
+func (e *Engine) StatusOf(ctx context.Context, machine provider.VM) (Status, error) {
+	defer e.hangup()
+
+	out, err := e.run(ctx, machine, statusCmd)
+	if err != nil {
+		return Status{VM: machine}, err
+	}
+	status := parseStatus(out)
+	status.VM = machine
+	return status, nil
+}
+
+// install runs all provisioning steps which are not yet completed according to cp on a running virtual machine,
+// setting up the self-destruct mechanism and the docker-pptpd container
+func (e *Engine) install(ctx context.Context, deployment *Deployment, cp checkpoint) *ProvisionError {
+	p := e.Provider.WithContext(ctx)
+	cfg := p.GetConfig()
+	machine := deployment.VM
+
+	call := func(cmd string) error {
+		out, err := e.run(ctx, machine, cmd)
+		if err == nil && !e.Progress.Quiet {
+			e.Progress.Println(out)
+		}
+		return err
+	}
+
+	steps := []struct {
+		name    string
+		message string
+		run     func() error
+	}{{
+		"update", "Update virtual machine", func() error {
+			if err := call(`apt-get update -qq`); err != nil {
+				return err
+			}
+			if err := call(`apt-get install -qy docker.io pptpd iptables curl at`); err != nil {
+				return err
+			}
+			_, err := e.run(ctx, machine, `service pptpd stop`)
+			return err
+		},
+	}, {
+		"self-destruct", "Setup self-destruct mechanism for virtual machine", func() error {
+			if err := e.client(machine).UploadSelfDestruct(ctx, cfg.SelfDestructFile); err != nil {
+				return err
+			}
+			if err := call( // abuse at for background task
+				fmt.Sprintf(`echo "/bin/bash /root/self-destruct.sh %s %s %s %d" | at now`,
+					cfg.Provider,
+					cfg.Providers[cfg.Provider].ApiKey,
+					machine.Id, cfg.Options.Uptime*60)); err != nil {
+				return err
+			}
+			deployment.Deadline = time.Now().Add(time.Duration(cfg.Options.Uptime) * time.Minute)
+			return nil
+		},
+	}, {
+		"credentials", "Generate username and password for pptpd", func() error {
+			username := rng.GenerateUsername()
+			password, err := e.generatePassword()
+			if err != nil {
+				return err
+			}
+			if _, err := e.run(ctx, machine, fmt.Sprintf(`echo "%s * %s *" > /chap-secrets`, username, password)); err != nil {
+				return err
+			}
+			deployment.Username = username
+			deployment.Password = password
+			return nil
+		},
+	}, {
+		"docker", "Setup docker on virtual machine", func() error {
+			if err := call(`service docker.io restart`); err != nil {
+				return err
+			}
+			return call(`docker pull jamesclonk/docker-pptpd`)
+		},
+	}, {
+		"pptpd", "Run docker-pptpd container on virtual machine", func() error {
+			if err := call(`docker rm -f pptpd >/dev/null 2>&1; ` +
+				`docker run --name pptpd --privileged -d -p 1723:1723 -v /chap-secrets:/etc/ppp/chap-secrets:ro jamesclonk/docker-pptpd`); err != nil {
+				return err
+			}
+			_, err := e.run(ctx, machine, sessionHooksCmd)
+			return err
+		},
+	}, {
+		"sshd", "Lock down SSH on virtual machine", func() error {
+			if err := checkSsh(cfg.GetSshUser(), cfg.GetSshPort()); err != nil {
+				return err
+			}
+			if _, err := e.run(ctx, machine, sshdCmd(cfg.GetSshUser(), cfg.GetSshPort())); err != nil {
+				return err
+			}
+			// the next step logs in anew, with the configured user and port
+			if err := e.client(machine).Close(); err != nil {
+				return err
+			}
+			return e.cloudFirewall(ctx, machine, true)
+		},
+	}, {
+		"firewall", "Setup firewall on virtual machine", func() error {
+			sources := e.sshSources()
+			if len(sources) == 0 {
+				e.Progress.Println("Warning: SSH stays open to anybody, no public IP of this machine or ssh_allow given")
+			}
+			subnet := cfg.Firewall.VpnSubnet
+			if len(subnet) == 0 {
+				subnet = DEFAULT_VPN_SUBNET
+			}
+			_, err := e.run(ctx, machine, firewallCmd(sources, cfg.GetSshPort(), subnet))
+			return err
+		},
+	}}
+
+	var completed []string
+	for _, step := range steps {
+		if cp.done(step.name) {
+			completed = append(completed, step.name)
+			continue
+		}
+
+		e.Progress.Println(step.message)
+		if err := ctx.Err(); err != nil {
+			return &ProvisionError{Step: step.name, VM: machine, Err: err}
+		}
+		if err := step.run(); err != nil {
+			return &ProvisionError{Step: step.name, VM: machine, Err: err}
+		}
+
+		// checkpoint on the machine itself, and locally if configured
+		if _, err := e.run(ctx, machine, fmt.Sprintf(`echo "%s" >> %s`, step.name, stepsFile)); err != nil {
+			return &ProvisionError{Step: step.name, VM: machine, Err: err}
+		}
+		completed = append(completed, step.name)
+		if len(e.StateDir) > 0 {
+			if err := record(e.StateDir, machine, completed); err != nil {
+				e.Progress.Printf("Could not record provisioning state locally: %v\n", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) run(ctx context.Context, machine provider.VM, cmd string) (string, error) {
+	out, err := e.client(machine).Run(ctx, cmd)
+	if err != nil {
+		return "", &SSHError{IP: machine.IP, Err: err}
+	}
+	return out, nil
+}
+
+// client returns the SSH connection to a virtual machine, shared by all commands run on it until hangup
+func (e *Engine) client(machine provider.VM) *ssh.Client {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.clients == nil {
+		e.clients = make(map[string]*ssh.Client)
+	}
+	client, ok := e.clients[machine.IP]
+	if !ok {
+		client = ssh.NewClient(e.Provider, machine)
+		e.clients[machine.IP] = client
+	}
+	return client
+}
+
+// hangup closes the SSH connections to all virtual machines
+func (e *Engine) hangup() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, client := range e.clients {
+		client.Close()
+	}
+	e.clients = nil
+}
+
+func (e *Engine) name() string {
+	if len(e.Name) == 0 {
+		return IDENTIFIER
+	}
+	return e.Name
+}
@@ -0,0 +1,121 @@
+package easyvpn
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var cfg *config.Config
+
+func init() {
+	var err error
+	cfg, err = config.LoadConfiguration("../fixtures/config_test.toml")
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func Test_EasyVpn_IsEasyVpn(t *testing.T) {
+	assert.True(t, IsEasyVpn("easy-vpn"))
+	assert.True(t, IsEasyVpn("easy-vpn-ams3"))
+	assert.False(t, IsEasyVpn("mockName"))
+	assert.False(t, IsEasyVpn("easy-vpnX"))
+}
+
+func Test_EasyVpn_Show(t *testing.T) {
+	e := New(test.MockProvider{
+		Config: cfg,
+		VMs: []provider.VM{
+			provider.VM{Name: "easy-vpn", Id: "mockId"},
+			provider.VM{Name: "mockName"},
+		},
+	})
+
+	machines, err := e.Show(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(machines))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.Show(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func Test_EasyVpn_Status_Inactive(t *testing.T) {
+	e := New(test.MockProvider{
+		Config: cfg,
+		VMs: []provider.VM{
+			provider.VM{Name: "easy-vpn", Id: "mockId", Status: "new"},
+			provider.VM{Name: "mockName", Status: "active"},
+		},
+	})
+
+	statuses, err := e.Status(context.Background())
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(statuses)) {
+		assert.Equal(t, "mockId", statuses[0].VM.Id)
+		assert.Nil(t, statuses[0].Err)
+		assert.False(t, statuses[0].VpnRunning)
+	}
+}
+
+func Test_EasyVpn_Down(t *testing.T) {
+	e := New(test.MockProvider{
+		Config: cfg,
+		VMs: []provider.VM{
+			provider.VM{Name: "easy-vpn", Id: "mockId"},
+		},
+	})
+
+	machine, err := e.Down(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "mockId", machine.Id)
+
+	e.Name = "does not exist"
+	_, err = e.Down(context.Background())
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func Test_EasyVpn_Up_Canceled(t *testing.T) {
+	e := New(test.MockProvider{Config: cfg})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deployment, err := e.Up(ctx)
+	assert.Nil(t, deployment)
+	if perr, ok := err.(*ProvisionError); assert.True(t, ok) {
+		assert.Equal(t, "ssh-key", perr.Step)
+		assert.Equal(t, context.Canceled, perr.Err)
+		assert.False(t, perr.Created)
+	}
+}
+
+func Test_EasyVpn_Credentials_NotFound(t *testing.T) {
+	e := New(test.MockProvider{
+		Config: cfg,
+		VMs: []provider.VM{
+			provider.VM{Name: "mockName", Id: "mockId"},
+		},
+	})
+
+	deployment, err := e.Credentials(context.Background(), false)
+	assert.Nil(t, deployment)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func Test_EasyVpn_ProvisionError(t *testing.T) {
+	err := &ProvisionError{
+		Step: "credentials",
+		VM:   provider.VM{Name: "easy-vpn", Id: "mockId"},
+		Err:  &SSHError{IP: "198.51.100.1", Err: errors.New("Process exited with status 1")},
+	}
+	assert.Equal(t, "Provisioning of virtual machine [easy-vpn] failed at step [credentials]: "+
+		"Could not run command through SSH on [198.51.100.1]\nProcess exited with status 1", err.Error())
+}
@@ -0,0 +1,76 @@
+package easyvpn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+)
+
+var (
+	// ErrNotFound is returned if the virtual machine of an Engine does not exist
+	ErrNotFound = errors.New("Virtual machine does not exist")
+
+	// ErrAlreadyRunning is returned by Up if pptpd is already running on the virtual machine
+	ErrAlreadyRunning = errors.New("pptpd is already running on virtual machine")
+
+	// ErrNoCredentials is returned by Credentials if the virtual machine has no VPN credentials set up yet
+	ErrNoCredentials = errors.New("No VPN credentials found on virtual machine")
+
+	// ErrUserExists is returned by AddUser if there already is a VPN user of that name
+	ErrUserExists = errors.New("VPN user already exists")
+
+	// ErrUserNotFound is returned if there is no VPN user of the given name
+	ErrUserNotFound = errors.New("VPN user does not exist")
+)
+
+// ProviderError is returned if a call to the cloud VPS provider API failed
+type ProviderError struct {
+	Op  string
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("Could not %s: %v", e.Op, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ProvisionError is returned if Up failed at one of its steps.
+// VM is set as soon as the virtual machine exists, Created tells if it was newly created by this Up,
+// which allows callers to clean up half-created virtual machines.
+type ProvisionError struct {
+	Step    string
+	VM      provider.VM
+	Created bool
+	Err     error
+}
+
+func (e *ProvisionError) Error() string {
+	if len(e.VM.Id) > 0 {
+		return fmt.Sprintf("Provisioning of virtual machine [%s] failed at step [%s]: %v", e.VM.Name, e.Step, e.Err)
+	}
+	return fmt.Sprintf("Provisioning failed at step [%s]: %v", e.Step, e.Err)
+}
+
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// SSHError is returned if a command could not be run on a virtual machine.
+// The command is left out, it might contain the provider API key or VPN passwords,
+// a ProvisionError around it tells the step instead.
+type SSHError struct {
+	IP  string
+	Err error
+}
+
+func (e *SSHError) Error() string {
+	return fmt.Sprintf("Could not run command through SSH on [%s]\n%v", e.IP, e.Err)
+}
+
+func (e *SSHError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,155 @@
+package easyvpn
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+)
+
+// DEFAULT_VPN_SUBNET are the addresses docker-pptpd hands out to VPN clients, if no vpn_subnet is configured
+const DEFAULT_VPN_SUBNET = "10.99.99.0/24"
+
+// the VPN ports, PPTP needs GRE besides its control connection
+var vpnRules = []provider.FirewallRule{
+	{Protocol: "tcp", Port: "1723"},
+	{Protocol: "gre"},
+	{Protocol: "icmp"},
+}
+
+// sshSources are the CIDRs allowed to SSH into the virtual machine, anybody if empty
+func (e *Engine) sshSources() []string {
+	return append(append([]string{}, e.AdminIPs...), e.Provider.GetConfig().Firewall.SshAllow...)
+}
+
+// firewallCmd drops all incoming traffic on the virtual machine but SSH from the given sources and the VPN itself,
+// and NATs the traffic of the VPN clients. It can safely be run again, and keeps the current SSH connection.
+func firewallCmd(sources []string, sshPort int, subnet string) string {
+	var cmd []string
+	add := func(format string, a ...interface{}) {
+		cmd = append(cmd, fmt.Sprintf(format, a...))
+	}
+
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		add(`%s -N easy-vpn 2>/dev/null; %s -F easy-vpn`, iptables, iptables)
+		add(`%s -A easy-vpn -i lo -j ACCEPT`, iptables)
+		add(`%s -A easy-vpn -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT`, iptables)
+	}
+	if len(sources) == 0 {
+		add(`iptables -A easy-vpn -p tcp --dport %d -j ACCEPT`, sshPort)
+		add(`ip6tables -A easy-vpn -p tcp --dport %d -j ACCEPT`, sshPort)
+	}
+	for _, source := range sources {
+		if strings.Contains(source, ":") {
+			add(`ip6tables -A easy-vpn -p tcp --dport %d -s %s -j ACCEPT`, sshPort, source)
+		} else {
+			add(`iptables -A easy-vpn -p tcp --dport %d -s %s -j ACCEPT`, sshPort, source)
+		}
+	}
+	add(`iptables -A easy-vpn -p tcp --dport 1723 -j ACCEPT`)
+	add(`iptables -A easy-vpn -p gre -j ACCEPT`)
+	add(`iptables -A easy-vpn -p icmp -j ACCEPT`)
+	add(`ip6tables -A easy-vpn -p ipv6-icmp -j ACCEPT`)
+	add(`iptables -A easy-vpn -i ppp+ -j ACCEPT`)
+	add(`iptables -A easy-vpn -i docker0 -j ACCEPT`)
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		add(`%s -A easy-vpn -j DROP`, iptables)
+		add(`(%s -C INPUT -j easy-vpn 2>/dev/null || %s -I INPUT -j easy-vpn)`, iptables, iptables)
+	}
+
+	add(`sysctl -qw net.ipv4.ip_forward=1`)
+	add(`(iptables -t nat -C POSTROUTING -s %s -j MASQUERADE 2>/dev/null || iptables -t nat -A POSTROUTING -s %s -j MASQUERADE)`, subnet, subnet)
+	return strings.Join(cmd, " && \\\n")
+}
+
+// firewallName of a virtual machine, the id tells apart leftovers of previous machines of the same name,
+// e.g. after they self-destructed
+func firewallName(machine provider.VM) string {
+	return machine.Name + "." + machine.Id
+}
+
+// cloudFirewall creates the cloud firewall of the provider for the virtual machine, unless there already is one,
+// and deletes those of previous machines of the same name. Port 22 stays open until the sshd step moved sshd
+// to the configured port, then the firewall is replaced by one without it. Providers that would block the VPN are skipped.
+func (e *Engine) cloudFirewall(ctx context.Context, machine provider.VM, sshdDone bool) error {
+	p := e.Provider.WithContext(ctx)
+	firewalls, err := p.GetFirewalls()
+	if err != nil {
+		return &ProviderError{Op: "retrieve list of firewalls", Err: err}
+	}
+
+	port := e.Provider.GetConfig().GetSshPort()
+	initial, locked := firewallName(machine), firewallName(machine)+".sshd"
+	name := initial
+	if sshdDone && port != config.DEFAULT_SSH_PORT {
+		name = locked
+	}
+
+	exists := false
+	var replaced []provider.Firewall
+	for _, firewall := range firewalls {
+		switch {
+		case firewall.Name == name || (!sshdDone && firewall.Name == locked):
+			exists = true
+		case firewall.Name == initial || firewall.Name == locked:
+			replaced = append(replaced, firewall)
+		case strings.HasPrefix(firewall.Name, machine.Name+"."):
+			e.Progress.Printf("Delete leftover firewall [%s]\n", firewall.Name)
+			if err := p.DeleteFirewall(firewall.Id); err != nil {
+				e.Progress.Printf("Could not delete firewall [%s]: %v\n", firewall.Name, err)
+			}
+		}
+	}
+	if exists {
+		return nil
+	}
+
+	var rules []provider.FirewallRule
+	if name == initial {
+		rules = append(rules, provider.FirewallRule{Protocol: "tcp", Port: "22", Sources: e.sshSources()})
+	}
+	if port != config.DEFAULT_SSH_PORT {
+		rules = append(rules, provider.FirewallRule{Protocol: "tcp", Port: strconv.Itoa(port), Sources: e.sshSources()})
+	}
+	rules = append(rules, vpnRules...)
+	e.Progress.Printf("Create firewall [%s]\n", name)
+	if _, err := p.CreateFirewall(name, machine.Id, rules); err != nil {
+		if err == provider.ErrFirewallUnsupported {
+			if !sshdDone {
+				e.Progress.Printf("Warning: No cloud firewall, %s can not allow PPTP (GRE) through it. "+
+					"The virtual machine is only protected by its own firewall.\n", p.GetProviderName())
+			}
+			return nil
+		}
+		return &ProviderError{Op: "create firewall", Err: err}
+	}
+
+	// the new firewall is in place before the one with port 22 goes away
+	for _, firewall := range replaced {
+		e.Progress.Printf("Delete firewall [%s]\n", firewall.Name)
+		if err := p.DeleteFirewall(firewall.Id); err != nil {
+			return &ProviderError{Op: fmt.Sprintf("delete firewall [%s]", firewall.Name), Err: err}
+		}
+	}
+	return nil
+}
+
+// deleteCloudFirewall deletes the cloud firewalls of a destroyed virtual machine, and leftovers of previous ones
+func (e *Engine) deleteCloudFirewall(ctx context.Context, machine provider.VM) error {
+	p := e.Provider.WithContext(ctx)
+	firewalls, err := p.GetFirewalls()
+	if err != nil {
+		return &ProviderError{Op: "retrieve list of firewalls", Err: err}
+	}
+	for _, firewall := range firewalls {
+		if strings.HasPrefix(firewall.Name, machine.Name+".") {
+			if err := p.DeleteFirewall(firewall.Id); err != nil {
+				return &ProviderError{Op: fmt.Sprintf("delete firewall [%s]", firewall.Name), Err: err}
+			}
+		}
+	}
+	return nil
+}
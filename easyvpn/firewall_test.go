@@ -0,0 +1,145 @@
+package easyvpn
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// firewallProvider records the firewalls created and deleted
+type firewallProvider struct {
+	test.MockProvider
+	unsupported bool
+	created     *[]string
+	rules       *[]provider.FirewallRule
+	deleted     *[]string
+}
+
+func (p firewallProvider) WithContext(ctx context.Context) provider.API {
+	return p
+}
+
+func (p firewallProvider) CreateFirewall(name, vmId string, rules []provider.FirewallRule) (string, error) {
+	if p.unsupported {
+		return "", provider.ErrFirewallUnsupported
+	}
+	*p.created = append(*p.created, name)
+	if p.rules != nil {
+		*p.rules = rules
+	}
+	return name, nil
+}
+
+func (p firewallProvider) DeleteFirewall(id string) error {
+	*p.deleted = append(*p.deleted, id)
+	return nil
+}
+
+func Test_EasyVpn_FirewallCmd(t *testing.T) {
+	cmd := firewallCmd([]string{"203.0.113.7/32", "2001:db8::/32"}, 22, "10.99.99.0/24")
+	assert.Contains(t, cmd, "iptables -A easy-vpn -p tcp --dport 22 -s 203.0.113.7/32 -j ACCEPT")
+	assert.Contains(t, cmd, "ip6tables -A easy-vpn -p tcp --dport 22 -s 2001:db8::/32 -j ACCEPT")
+	assert.NotContains(t, cmd, "--dport 22 -j ACCEPT")
+	assert.Contains(t, cmd, "iptables -A easy-vpn -p gre -j ACCEPT")
+	assert.Contains(t, cmd, "iptables -t nat -A POSTROUTING -s 10.99.99.0/24 -j MASQUERADE")
+
+	// established connections, like the one running the command, come before anything is dropped
+	assert.True(t, strings.Index(cmd, "ESTABLISHED") < strings.Index(cmd, "-j DROP"))
+
+	cmd = firewallCmd(nil, 2222, "10.99.99.0/24")
+	assert.Contains(t, cmd, "iptables -A easy-vpn -p tcp --dport 2222 -j ACCEPT")
+	assert.NotContains(t, cmd, "--dport 22 ")
+}
+
+func Test_EasyVpn_SshSources(t *testing.T) {
+	e := New(test.MockProvider{Config: cfg})
+	e.AdminIPs = []string{"198.51.100.7/32"}
+	assert.Equal(t, []string{"198.51.100.7/32", "203.0.113.0/24"}, e.sshSources())
+	assert.Equal(t, []string{"203.0.113.0/24"}, cfg.Firewall.SshAllow)
+}
+
+func Test_EasyVpn_CloudFirewall(t *testing.T) {
+	var created, deleted []string
+	p := firewallProvider{
+		MockProvider: test.MockProvider{
+			Config: cfg,
+			Firewalls: []provider.Firewall{
+				{Id: "old", Name: "easy-vpn.123"},
+				{Id: "fleet", Name: "easy-vpn-ams3.456"},
+			},
+		},
+		created: &created,
+		deleted: &deleted,
+	}
+	e := New(p)
+	e.Progress.Writer = nil
+	machine := provider.VM{Id: "789", Name: "easy-vpn"}
+
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, false))
+	assert.Equal(t, []string{"easy-vpn.789"}, created)
+	assert.Equal(t, []string{"old"}, deleted)
+
+	// already there
+	created, deleted = nil, nil
+	p.Firewalls = []provider.Firewall{{Id: "new", Name: "easy-vpn.789"}}
+	e.Provider = p
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, false))
+	assert.Nil(t, created)
+
+	assert.Nil(t, e.deleteCloudFirewall(context.Background(), machine))
+	assert.Equal(t, []string{"new"}, deleted)
+
+	// skipped if the provider would block the VPN
+	p.Firewalls = nil
+	p.unsupported = true
+	e.Provider = p
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, false))
+
+	// nothing to replace without a custom ssh_port
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, true))
+}
+
+func Test_EasyVpn_CloudFirewall_SshPort(t *testing.T) {
+	sshCfg := *cfg
+	sshCfg.SshPort = 2222
+	var created, deleted []string
+	var rules []provider.FirewallRule
+	p := firewallProvider{
+		MockProvider: test.MockProvider{Config: &sshCfg},
+		created:      &created,
+		deleted:      &deleted,
+		rules:        &rules,
+	}
+	e := New(p)
+	e.Progress.Writer = nil
+	machine := provider.VM{Id: "789", Name: "easy-vpn"}
+
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, false))
+	assert.Equal(t, []string{"easy-vpn.789"}, created)
+	assert.Equal(t, "22", rules[0].Port)
+	assert.Equal(t, "2222", rules[1].Port)
+
+	// port 22 is closed once sshd moved
+	created = nil
+	p.Firewalls = []provider.Firewall{{Id: "initial", Name: "easy-vpn.789"}}
+	e.Provider = p
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, true))
+	assert.Equal(t, []string{"easy-vpn.789.sshd"}, created)
+	assert.Equal(t, []string{"initial"}, deleted)
+	assert.Equal(t, "2222", rules[0].Port)
+	for _, rule := range rules {
+		assert.NotEqual(t, "22", rule.Port)
+	}
+
+	// a resumed Up keeps it
+	created, deleted = nil, nil
+	p.Firewalls = []provider.Firewall{{Id: "locked", Name: "easy-vpn.789.sshd"}}
+	e.Provider = p
+	assert.Nil(t, e.cloudFirewall(context.Background(), machine, false))
+	assert.Nil(t, created)
+	assert.Nil(t, deleted)
+}
@@ -0,0 +1,59 @@
+package easyvpn
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sshdConfigCopy is where sshdCmd edits the sshd configuration before validating it
+const sshdConfigCopy = "/etc/ssh/sshd_config.easy-vpn"
+
+var validSshUser = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// checkSsh validates the configured SSH user and port, before locking anybody out of the virtual machine
+func checkSsh(user string, port int) error {
+	if !validSshUser.MatchString(user) {
+		return fmt.Errorf("Invalid ssh_user: %q", user)
+	}
+	if port < 1 || port > 65535 || port == 1723 {
+		return fmt.Errorf("Invalid ssh_port: %d", port)
+	}
+	return nil
+}
+
+// sshdCmd locks down the SSH daemon of the virtual machine: keys only, a dedicated user with sudo instead of root,
+// unless the user is root, and the given port. The current SSH connection survives the restart of sshd.
+func sshdCmd(user string, port int) string {
+	var cmd []string
+	add := func(format string, a ...interface{}) {
+		cmd = append(cmd, fmt.Sprintf(format, a...))
+	}
+
+	// without-password is what OpenSSH before 7.0 calls prohibit-password, newer ones still accept it
+	rootLogin := "without-password"
+	if user != "root" {
+		home := "/home/" + user
+		add(`(id -u %s >/dev/null 2>&1 || useradd -m -s /bin/bash %s)`, user, user)
+		add(`mkdir -p %s/.ssh && cp /root/.ssh/authorized_keys %s/.ssh/authorized_keys`, home, home)
+		add(`chown -R %s:%s %s/.ssh && chmod 700 %s/.ssh && chmod 600 %s/.ssh/authorized_keys`, user, user, home, home, home)
+		add(`echo "%s ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/easy-vpn && chmod 440 /etc/sudoers.d/easy-vpn`, user)
+		rootLogin = "no"
+	}
+
+	// edit a copy, sshd_config is only replaced once the copy is known to be valid, an invalid one would lock everybody out
+	add(`cp /etc/ssh/sshd_config %s`, sshdConfigCopy)
+	add(`sed -i -E 's/^#?PasswordAuthentication .*/PasswordAuthentication no/; s/^#?PermitRootLogin .*/PermitRootLogin %s/; s/^#?Port .*/Port %d/' %s`, rootLogin, port, sshdConfigCopy)
+	add(`(grep -q "^PasswordAuthentication " %s || echo "PasswordAuthentication no" >> %s)`, sshdConfigCopy, sshdConfigCopy)
+	add(`(grep -q "^PermitRootLogin " %s || echo "PermitRootLogin %s" >> %s)`, sshdConfigCopy, rootLogin, sshdConfigCopy)
+	add(`(grep -q "^Port " %s || echo "Port %d" >> %s)`, sshdConfigCopy, port, sshdConfigCopy)
+	add(`(/usr/sbin/sshd -t -f %s || (rm -f %s; false))`, sshdConfigCopy, sshdConfigCopy)
+	add(`mv %s /etc/ssh/sshd_config`, sshdConfigCopy)
+	// newer releases start sshd through a systemd socket, which listens on the port itself
+	add(`if systemctl is-enabled ssh.socket >/dev/null 2>&1; then `+
+		`mkdir -p /etc/systemd/system/ssh.socket.d && `+
+		`printf "[Socket]\nListenStream=\nListenStream=%d\n" > /etc/systemd/system/ssh.socket.d/easy-vpn.conf && `+
+		`systemctl daemon-reload && systemctl restart ssh.socket; fi`, port)
+	add(`(service ssh restart || service sshd restart) >/dev/null 2>&1`)
+	return strings.Join(cmd, " && \\\n")
+}
@@ -0,0 +1,40 @@
+package easyvpn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EasyVpn_SshdCmd(t *testing.T) {
+	cmd := sshdCmd("easyvpn", 2222)
+	assert.Contains(t, cmd, "useradd -m -s /bin/bash easyvpn")
+	assert.Contains(t, cmd, "cp /root/.ssh/authorized_keys /home/easyvpn/.ssh/authorized_keys")
+	assert.Contains(t, cmd, `echo "easyvpn ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/easy-vpn`)
+	assert.Contains(t, cmd, "s/^#?PermitRootLogin .*/PermitRootLogin no/")
+	assert.Contains(t, cmd, "s/^#?PasswordAuthentication .*/PasswordAuthentication no/")
+	assert.Contains(t, cmd, "s/^#?Port .*/Port 2222/")
+	assert.Contains(t, cmd, "s/^#?Port .*/Port 2222/' /etc/ssh/sshd_config.easy-vpn &&")
+
+	// the edited copy is validated before it replaces sshd_config
+	validate := strings.Index(cmd, "sshd -t -f /etc/ssh/sshd_config.easy-vpn")
+	replace := strings.Index(cmd, "mv /etc/ssh/sshd_config.easy-vpn /etc/ssh/sshd_config")
+	assert.True(t, validate > 0 && validate < replace)
+	assert.Contains(t, cmd, "ListenStream=2222")
+
+	cmd = sshdCmd("root", 22)
+	assert.NotContains(t, cmd, "useradd")
+	assert.Contains(t, cmd, "PermitRootLogin without-password")
+	assert.NotContains(t, cmd, "prohibit-password")
+	assert.Contains(t, cmd, "Port 22")
+}
+
+func Test_EasyVpn_CheckSsh(t *testing.T) {
+	assert.Nil(t, checkSsh("root", 22))
+	assert.Nil(t, checkSsh("easy-vpn", 2222))
+	assert.NotNil(t, checkSsh("Bob; rm -rf /", 22))
+	assert.NotNil(t, checkSsh("easyvpn", 0))
+	assert.NotNil(t, checkSsh("easyvpn", 70000))
+	assert.NotNil(t, checkSsh("easyvpn", 1723))
+}
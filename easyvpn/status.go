@@ -0,0 +1,81 @@
+package easyvpn
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+)
+
+// statusCmd collects everything "easy-vpn status" reports in one SSH roundtrip
+const statusCmd = `echo "now=$(date +%s)"
+echo "deadline=$(cat /root/self-destruct.deadline 2>/dev/null)"
+ps -ef | grep -q "[s]elf-destruct.sh" && echo "watchdog=running" || echo "watchdog=missing"
+echo "vpn=$(docker inspect -f '{{.State.Running}}' pptpd 2>/dev/null)"
+echo "load=$(cut -d' ' -f1-3 /proc/loadavg)"
+docker exec pptpd cat /proc/net/dev 2>/dev/null | grep ppp | sed 's/^/ppp=/'
+echo "..."`
+
+// Status is the state of a virtual machine and its VPN server as reported by the machine itself
+type Status struct {
+	VM         provider.VM
+	Deadline   time.Time // when the machine will self-destruct, zero if unknown
+	Remaining  time.Duration
+	Watchdog   bool // is self-destruct.sh running
+	VpnRunning bool
+	Clients    int
+	BytesIn    uint64
+	BytesOut   uint64
+	Load       string
+	Err        error // set by Engine.Status if the machine could not be inspected
+}
+
+func parseStatus(out string) (status Status) {
+	var now int64
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], strings.TrimSpace(parts[1])
+
+		switch key {
+		case "now":
+			now, _ = strconv.ParseInt(value, 10, 64)
+		case "deadline":
+			if deadline, err := strconv.ParseInt(value, 10, 64); err == nil {
+				status.Deadline = time.Unix(deadline, 0)
+			}
+		case "watchdog":
+			status.Watchdog = value == "running"
+		case "vpn":
+			status.VpnRunning = value == "true"
+		case "load":
+			status.Load = value
+		case "ppp":
+			// /proc/net/dev format: "ppp0: rx_bytes rx_packets ... (8 fields) tx_bytes ..."
+			fields := strings.Fields(strings.Replace(value, ":", " ", 1))
+			if len(fields) < 10 {
+				continue
+			}
+			status.Clients++
+			// traffic is reported from the clients point of view,
+			// what a ppp interface received was sent by the client and vice versa
+			if bytes, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				status.BytesOut += bytes
+			}
+			if bytes, err := strconv.ParseUint(fields[9], 10, 64); err == nil {
+				status.BytesIn += bytes
+			}
+		}
+	}
+
+	if !status.Deadline.IsZero() && now > 0 {
+		status.Remaining = time.Duration(status.Deadline.Unix()-now) * time.Second
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+	}
+	return status
+}
@@ -0,0 +1,37 @@
+package easyvpn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Status_ParseStatus(t *testing.T) {
+	status := parseStatus(`now=1420070400
+deadline=1420074000
+watchdog=running
+vpn=true
+load=0.08 0.03 0.05
+ppp=  ppp0:  12345     100    0    0    0     0          0         0   678900     200    0    0    0     0       0          0
+ppp=  ppp1:   1000      10    0    0    0     0          0         0     2000      20    0    0    0     0       0          0
+...
+`)
+	assert.Equal(t, time.Unix(1420074000, 0), status.Deadline)
+	assert.Equal(t, time.Hour, status.Remaining)
+	assert.True(t, status.Watchdog)
+	assert.True(t, status.VpnRunning)
+	assert.Equal(t, "0.08 0.03 0.05", status.Load)
+	assert.Equal(t, 2, status.Clients)
+	assert.Equal(t, uint64(13345), status.BytesOut)
+	assert.Equal(t, uint64(680900), status.BytesIn)
+}
+
+func Test_Status_ParseStatus_MissingWatchdog(t *testing.T) {
+	status := parseStatus("now=1420070400\ndeadline=\nwatchdog=missing\nvpn=\nload=1.00 1.00 1.00\n...\n")
+	assert.True(t, status.Deadline.IsZero())
+	assert.Equal(t, time.Duration(0), status.Remaining)
+	assert.False(t, status.Watchdog)
+	assert.False(t, status.VpnRunning)
+	assert.Equal(t, 0, status.Clients)
+}
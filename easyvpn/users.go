@@ -0,0 +1,206 @@
+package easyvpn
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/rng"
+	"github.com/JamesClonk/easy-vpn/vm"
+)
+
+// User is one set of VPN credentials of the virtual machine
+type User struct {
+	Username string
+	Password string
+}
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// sessionHooksCmd installs ppp hooks inside the pptpd container, which keep track of the pppd process
+// of each connected user, so the sessions of a single user can be disconnected without affecting anybody else
+const sessionHooksCmd = `docker exec pptpd sh -c 'mkdir -p /var/run/easy-vpn /etc/ppp/ip-up.d /etc/ppp/ip-down.d
+echo "#!/bin/sh" > /etc/ppp/ip-up.d/easy-vpn
+echo "echo \"\$PEERNAME\" > /var/run/easy-vpn/\$PPPD_PID" >> /etc/ppp/ip-up.d/easy-vpn
+echo "#!/bin/sh" > /etc/ppp/ip-down.d/easy-vpn
+echo "rm -f /var/run/easy-vpn/\$PPPD_PID" >> /etc/ppp/ip-down.d/easy-vpn
+chmod +x /etc/ppp/ip-up.d/easy-vpn /etc/ppp/ip-down.d/easy-vpn'`
+
+// Users lists all VPN users of the virtual machine
+func (e *Engine) Users(ctx context.Context) ([]User, error) {
+	defer e.hangup()
+
+	machine, err := e.machine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.users(ctx, machine)
+}
+
+// AddUser adds a new VPN user to the virtual machine, with a generated username if none is given
+func (e *Engine) AddUser(ctx context.Context, username string) (User, error) {
+	defer e.hangup()
+
+	if len(username) == 0 {
+		username = rng.GenerateUsername()
+	}
+	if !validUsername.MatchString(username) {
+		return User{}, fmt.Errorf("Invalid username: %q", username)
+	}
+
+	machine, err := e.machine(ctx)
+	if err != nil {
+		return User{}, err
+	}
+	users, err := e.users(ctx, machine)
+	if err != nil {
+		return User{}, err
+	}
+	if index(users, username) >= 0 {
+		return User{}, ErrUserExists
+	}
+
+	password, err := e.generatePassword()
+	if err != nil {
+		return User{}, err
+	}
+	user := User{Username: username, Password: password}
+	if err := e.writeUsers(ctx, machine, append(users, user)); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// RemoveUser revokes the credentials of a VPN user and disconnects its sessions
+func (e *Engine) RemoveUser(ctx context.Context, username string) error {
+	defer e.hangup()
+
+	machine, err := e.machine(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := e.users(ctx, machine)
+	if err != nil {
+		return err
+	}
+	i := index(users, username)
+	if i < 0 {
+		return ErrUserNotFound
+	}
+
+	if err := e.writeUsers(ctx, machine, append(users[:i], users[i+1:]...)); err != nil {
+		return err
+	}
+	return e.disconnect(ctx, machine, username)
+}
+
+// RotateUser generates a new password for a VPN user and disconnects its sessions, which have to log in again with it
+func (e *Engine) RotateUser(ctx context.Context, username string) (User, error) {
+	defer e.hangup()
+
+	machine, err := e.machine(ctx)
+	if err != nil {
+		return User{}, err
+	}
+	users, err := e.users(ctx, machine)
+	if err != nil {
+		return User{}, err
+	}
+	i := index(users, username)
+	if i < 0 {
+		return User{}, ErrUserNotFound
+	}
+
+	password, err := e.generatePassword()
+	if err != nil {
+		return User{}, err
+	}
+	users[i].Password = password
+	if err := e.writeUsers(ctx, machine, users); err != nil {
+		return User{}, err
+	}
+	return users[i], e.disconnect(ctx, machine, username)
+}
+
+// generatePassword follows the configured password policy
+func (e *Engine) generatePassword() (string, error) {
+	cfg := e.Provider.GetConfig().Credentials
+	policy := rng.DefaultPolicy
+	if cfg.PasswordLength > 0 {
+		policy.Length = cfg.PasswordLength
+	}
+	if len(cfg.PasswordClasses) > 0 {
+		policy.Classes = cfg.PasswordClasses
+	}
+	policy.Words = cfg.PassphraseWords
+	return policy.Generate()
+}
+
+func (e *Engine) machine(ctx context.Context) (provider.VM, error) {
+	machine, exists, err := vm.Find(e.Provider.WithContext(ctx), e.name())
+	if err != nil {
+		return machine, &ProviderError{Op: "retrieve list of virtual machines", Err: err}
+	}
+	if !exists {
+		return machine, ErrNotFound
+	}
+	return machine, nil
+}
+
+func (e *Engine) users(ctx context.Context, machine provider.VM) ([]User, error) {
+	out, err := e.run(ctx, machine, `cat /chap-secrets 2>/dev/null; echo "..."`)
+	if err != nil {
+		return nil, err
+	}
+	return parseUsers(out), nil
+}
+
+// writeUsers rewrites /chap-secrets in place, the file is bind mounted into the pptpd container
+// and pppd reads it on every login, so there is no need to restart pptpd and drop the sessions of other users
+func (e *Engine) writeUsers(ctx context.Context, machine provider.VM, users []User) error {
+	cmd := `: > /chap-secrets`
+	if len(users) > 0 {
+		cmd = `printf '%s\n'`
+		for _, user := range users {
+			cmd += fmt.Sprintf(` '%s * %s *'`, user.Username, user.Password)
+		}
+		cmd += ` > /chap-secrets`
+	}
+	if _, err := e.run(ctx, machine, cmd); err != nil {
+		return err
+	}
+	_, err := e.run(ctx, machine, sessionHooksCmd)
+	return err
+}
+
+// disconnect kills the pppd processes of all sessions of a user, as recorded by the session hooks
+func (e *Engine) disconnect(ctx context.Context, machine provider.VM, username string) error {
+	_, err := e.run(ctx, machine, fmt.Sprintf(`docker exec pptpd sh -c 'for f in /var/run/easy-vpn/*; do `+
+		`[ "$(cat $f 2>/dev/null)" = "%s" ] && kill $(basename $f) && rm -f $f; done; true'`, username))
+	return err
+}
+
+// parseUsers reads all users from chap-secrets lines: "<username> * <password> *"
+func parseUsers(out string) (users []User) {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		username, password := parseSecrets(line)
+		if len(username) > 0 {
+			users = append(users, User{Username: username, Password: password})
+		}
+	}
+	return users
+}
+
+func index(users []User, username string) int {
+	for i, user := range users {
+		if user.Username == username {
+			return i
+		}
+	}
+	return -1
+}
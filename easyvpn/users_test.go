@@ -0,0 +1,38 @@
+package easyvpn
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EasyVpn_ParseUsers(t *testing.T) {
+	users := parseUsers(`# secrets for authentication using CHAP
+jdoe * s3cr3t *
+alice * t0p53cr3t *
+
+...`)
+
+	if assert.Equal(t, 2, len(users)) {
+		assert.Equal(t, User{Username: "jdoe", Password: "s3cr3t"}, users[0])
+		assert.Equal(t, User{Username: "alice", Password: "t0p53cr3t"}, users[1])
+	}
+	assert.Equal(t, 1, index(users, "alice"))
+	assert.Equal(t, -1, index(users, "bob"))
+
+	assert.Nil(t, parseUsers("..."))
+}
+
+func Test_EasyVpn_AddUser_Invalid(t *testing.T) {
+	e := New(test.MockProvider{Config: cfg})
+
+	_, err := e.AddUser(context.Background(), "bob'; rm -rf /")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Invalid username")
+	}
+
+	_, err = e.AddUser(context.Background(), "bob")
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/export"
+	"github.com/codegangsta/cli"
+)
+
+func exportProfile(c *cli.Context) {
+	format := c.String("format")
+	if len(format) == 0 {
+		fail(c, fmt.Errorf("Missing --format, one of: %v", export.Formats()))
+	}
+	if c.Bool("qr") && !export.Mobile(format) {
+		fail(c, fmt.Errorf("Format [%s] is not meant to be scanned by mobile devices", format))
+	}
+
+	e := getEngine(c)
+	deployment, err := e.Credentials(context.Background(), false)
+	if err != nil {
+		fail(c, err)
+	}
+	user := easyvpn.User{Username: deployment.Username, Password: deployment.Password}
+	if username := c.String("user"); len(username) > 0 {
+		if user, err = findUser(e, username); err != nil {
+			fail(c, err)
+		}
+	}
+
+	profile, _, err := export.Render(format, export.Profile{
+		Name:     deployment.VM.Name,
+		Protocol: deployment.Protocol,
+		Server:   deployment.VM.IP,
+		Port:     deployment.Port,
+		Username: user.Username,
+		Password: user.Password,
+	})
+	if err != nil {
+		fail(c, err)
+	}
+
+	if filename := c.String("file"); len(filename) > 0 {
+		if err := ioutil.WriteFile(filename, []byte(profile), 0600); err != nil {
+			fail(c, fmt.Errorf("Could not write file: %s\n%v", filename, err))
+		}
+		fmt.Fprintf(os.Stderr, "Profile for VPN user [%s] written to [%s]\n", user.Username, filename)
+	} else {
+		fmt.Print(profile)
+	}
+
+	if c.Bool("qr") {
+		code, err := export.QRCode(profile)
+		if err != nil {
+			fail(c, err)
+		}
+		fmt.Fprint(os.Stderr, code)
+	}
+}
+
+func findUser(e *easyvpn.Engine, username string) (easyvpn.User, error) {
+	users, err := e.Users(context.Background())
+	if err != nil {
+		return easyvpn.User{}, err
+	}
+	for _, user := range users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return easyvpn.User{}, easyvpn.ErrUserNotFound
+}
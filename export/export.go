@@ -0,0 +1,86 @@
+package export
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Profile is everything a VPN client needs to connect to the VPN server
+type Profile struct {
+	Name     string // of the connection within the client
+	Protocol string // "pptp", the only one the VPN server speaks so far
+	Server   string
+	Port     int
+	Username string
+	Password string
+}
+
+type format struct {
+	Extension string
+	Protocols []string
+	Mobile    bool // is it imported by scanning a QR code
+	render    func(p Profile) (string, error)
+}
+
+var formats = map[string]format{
+	"nm":           {".nmconnection", []string{"pptp"}, false, networkManager},
+	"rasphone":     {".pbk", []string{"pptp"}, false, rasphone},
+	"mobileconfig": {".mobileconfig", []string{"pptp"}, true, mobileconfig},
+}
+
+// Formats lists the names of all formats
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render returns the client profile in the given format, and the usual file extension of it
+func Render(name string, p Profile) (string, string, error) {
+	f, ok := formats[name]
+	if !ok {
+		return "", "", fmt.Errorf("Unknown format: %s", name)
+	}
+	if !contains(f.Protocols, p.Protocol) {
+		return "", "", fmt.Errorf("Format [%s] is not available for protocol [%s] of the VPN server", name, p.Protocol)
+	}
+	out, err := f.render(p)
+	return out, f.Extension, err
+}
+
+// Mobile tells whether a format is meant to be scanned from a QR code by mobile devices
+func Mobile(name string) bool {
+	return formats[name].Mobile
+}
+
+// QRCode renders the content as QR code for the terminal, two modules per character
+func QRCode(content string) (string, error) {
+	code, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", fmt.Errorf("Could not render QR code\n%v", err)
+	}
+	return code.ToSmallString(false), nil
+}
+
+// uuid is derived from the profile, so that exporting it again updates the connection instead of adding another one
+func uuid(p Profile, kind string) string {
+	sum := sha1.Sum([]byte(kind + "\x00" + p.Name + "\x00" + p.Server + "\x00" + p.Username))
+	sum[6] = (sum[6] & 0x0f) | 0x50 // version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // variant RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
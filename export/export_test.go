@@ -0,0 +1,81 @@
+package export
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var profile = Profile{
+	Name:     "easy-vpn",
+	Protocol: "pptp",
+	Server:   "198.51.100.1",
+	Port:     1723,
+	Username: "jdoe",
+	Password: "s3cr<e>t",
+}
+
+func Test_Export_Formats(t *testing.T) {
+	assert.Equal(t, []string{"mobileconfig", "nm", "rasphone"}, Formats())
+	assert.True(t, Mobile("mobileconfig"))
+	assert.False(t, Mobile("nm"))
+}
+
+func Test_Export_Render(t *testing.T) {
+	_, _, err := Render("pbk", profile)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Unknown format: pbk", err.Error())
+	}
+	_, _, err = Render("nm", Profile{Name: "easy-vpn", Protocol: "wireguard"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Format [nm] is not available for protocol [wireguard] of the VPN server", err.Error())
+	}
+}
+
+func Test_Export_NetworkManager(t *testing.T) {
+	out, extension, err := Render("nm", profile)
+	assert.Nil(t, err)
+	assert.Equal(t, ".nmconnection", extension)
+	assert.Contains(t, out, "\nid=easy-vpn\n")
+	assert.Contains(t, out, "\ngateway=198.51.100.1\n")
+	assert.Contains(t, out, "\nuser=jdoe\n")
+	assert.Contains(t, out, "\n[vpn-secrets]\npassword=s3cr<e>t\n")
+
+	// the same profile always gets the same uuid, so importing it again replaces the connection
+	again, _, _ := Render("nm", profile)
+	assert.Equal(t, out, again)
+	assert.Regexp(t, `\nuuid=[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\n`, out)
+}
+
+func Test_Export_Rasphone(t *testing.T) {
+	out, extension, err := Render("rasphone", profile)
+	assert.Nil(t, err)
+	assert.Equal(t, ".pbk", extension)
+	assert.True(t, strings.HasPrefix(out, `; connect with: rasdial "easy-vpn" "jdoe" *`+"\n[easy-vpn]\n"))
+	assert.Contains(t, out, "\nDevice=WAN Miniport (PPTP)\n")
+	assert.Contains(t, out, "\nPhoneNumber=198.51.100.1\n")
+	assert.NotContains(t, out, "s3cr<e>t")
+}
+
+func Test_Export_Mobileconfig(t *testing.T) {
+	out, _, err := Render("mobileconfig", profile)
+	assert.Nil(t, err)
+	assert.Contains(t, out, "<string>s3cr&lt;e&gt;t</string>")
+
+	// must be well-formed
+	decoder := xml.NewDecoder(strings.NewReader(out))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			assert.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
+
+func Test_Export_QRCode(t *testing.T) {
+	code, err := QRCode("<plist version=\"1.0\"></plist>\n")
+	assert.Nil(t, err)
+	assert.True(t, len(strings.Split(code, "\n")) > 10)
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// networkManager renders a keyfile, to be placed in /etc/NetworkManager/system-connections/
+func networkManager(p Profile) (string, error) {
+	return fmt.Sprintf(`[connection]
+id=%s
+uuid=%s
+type=vpn
+autoconnect=false
+
+[vpn]
+service-type=org.freedesktop.NetworkManager.pptp
+gateway=%s
+user=%s
+password-flags=0
+require-mppe=yes
+refuse-eap=yes
+refuse-pap=yes
+refuse-chap=yes
+refuse-mschap=yes
+
+[vpn-secrets]
+password=%s
+
+[ipv4]
+method=auto
+never-default=false
+
+[ipv6]
+method=ignore
+`, p.Name, uuid(p, "nm"), p.Server, p.Username, p.Password), nil
+}
+
+// rasphone renders a Windows phonebook entry, Windows does not keep passwords in there,
+// they have to be entered on connecting, rasdial asks for it given "*"
+func rasphone(p Profile) (string, error) {
+	return fmt.Sprintf(`; connect with: rasdial "%s" "%s" *
+[%s]
+Encoding=1
+Type=2
+AutoLogon=0
+UseRasCredentials=1
+DialParamsUID=0
+IpPrioritizeRemote=1
+IpInterfaceMetric=0
+VpnStrategy=1
+DataEncryption=256
+ExcludedProtocols=0
+PreviewUserPw=1
+PreviewDomain=0
+NETCOMPONENTS=ms_server,ms_msclient
+MEDIA=rastapi
+Port=VPN2-0
+Device=WAN Miniport (PPTP)
+DEVICE=vpn
+PhoneNumber=%s
+`, p.Name, p.Username, p.Name, p.Server), nil
+}
+
+// mobileconfig renders an Apple configuration profile. Beware that iOS 10 and macOS 10.12 dropped PPTP.
+func mobileconfig(p Profile) (string, error) {
+	var body bytes.Buffer
+	escape := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintf(&body, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadType</key>
+			<string>com.apple.vpn.managed</string>
+			<key>PayloadIdentifier</key>
+			<string>com.github.jamesclonk.easy-vpn.%s.vpn</string>
+			<key>PayloadUUID</key>
+			<string>%s</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+			<key>PayloadDisplayName</key>
+			<string>%s</string>
+			<key>UserDefinedName</key>
+			<string>%s</string>
+			<key>VPNType</key>
+			<string>PPTP</string>
+			<key>PPP</key>
+			<dict>
+				<key>CommRemoteAddress</key>
+				<string>%s</string>
+				<key>AuthName</key>
+				<string>%s</string>
+				<key>AuthPassword</key>
+				<string>%s</string>
+				<key>CCPEnabled</key>
+				<integer>1</integer>
+				<key>CCPMPPE128Enabled</key>
+				<integer>1</integer>
+			</dict>
+			<key>IPv4</key>
+			<dict>
+				<key>OverridePrimary</key>
+				<integer>1</integer>
+			</dict>
+		</dict>
+	</array>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadIdentifier</key>
+	<string>com.github.jamesclonk.easy-vpn.%s</string>
+	<key>PayloadUUID</key>
+	<string>%s</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+	<key>PayloadDisplayName</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, escape(p.Name), uuid(p, "mobileconfig-vpn"), escape(p.Name), escape(p.Name),
+		escape(p.Server), escape(p.Username), escape(p.Password),
+		escape(p.Name), uuid(p, "mobileconfig"), escape(p.Name))
+	return body.String(), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/ssh"
+	"github.com/JamesClonk/easy-vpn/vm"
+	"github.com/codegangsta/cli"
+)
+
+type fleetResult struct {
+	Region     string
+	Deployment easyvpn.Deployment
+	Err        error
+}
+
+func fleetUp(c *cli.Context) {
+	p := getProvider(c)
+
+	regions := parseRegions(c.String("regions"))
+	if len(regions) == 0 {
+		log.Fatal("No regions given, please specify them with --regions")
+	}
+
+	// all fleet vm's share the same easy-vpn ssh-key, unless each gets an ephemeral one of its own
+	var sshkeyId string
+	if !p.GetConfig().SshEphemeralKey {
+		var err error
+		sshkeyId, err = ssh.EasyVpnKeyId(p, EASYVPN_IDENTIFIER)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cancel := interruptible(c)
+	defer cancel()
+
+	admins := adminIPs(c)
+	out := &syncWriter{writer: os.Stdout}
+	results := make([]fleetResult, len(regions))
+	engines := make([]*easyvpn.Engine, len(regions))
+
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			progress := vm.Progress{
+				Writer: &prefixWriter{writer: out, prefix: "[" + region + "] "},
+				Quiet:  true,
+			}
+			regional, _ := newProvider(p.GetConfig().WithRegion(region)) // same provider as p, can not fail
+			e := &easyvpn.Engine{
+				Provider: regional,
+				Name:     fleetVmName(region),
+				SshKeyId: sshkeyId,
+				StateDir: regional.GetConfig().GetStateDir(),
+				AdminIPs: admins,
+				Progress: progress,
+			}
+			engines[i] = e
+			results[i] = startFleetVpn(ctx, e, region)
+			if results[i].Err != nil {
+				progress.Println(results[i].Err)
+			}
+		}(i, region)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if perr, ok := result.Err.(*easyvpn.ProvisionError); ok && perr.Created {
+			rollback(c, engines[i], perr.VM, ctx.Err() != nil)
+		}
+	}
+
+	fmt.Println("=========================================================================")
+	fmt.Fprintln(writer, "REGION\tNAME\tIP\tUSERNAME\tPASSWORD\tSTATUS")
+	failed := false
+	for _, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = "failed"
+			failed = true
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Region,
+			result.Deployment.VM.Name, result.Deployment.VM.IP, result.Deployment.Username, result.Deployment.Password, status)
+	}
+	writer.Flush()
+	fmt.Println("=========================================================================")
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func startFleetVpn(ctx context.Context, e *easyvpn.Engine, region string) (result fleetResult) {
+	result.Region = region
+
+	deployment, err := e.Up(ctx)
+	if deployment != nil {
+		result.Deployment = *deployment
+	}
+	result.Err = err
+	return
+}
+
+func fleetDown(c *cli.Context) {
+	p := getProvider(c)
+	regions := parseRegions(c.String("regions"))
+
+	machines, err := vm.GetAll(p)
+	if err != nil {
+		fail(c, err)
+	}
+
+	var targets []destroyTarget
+	for _, machine := range machines {
+		if isFleetVm(machine.Name, regions) {
+			targets = append(targets, destroyTarget{Provider: p, VM: machine})
+		}
+	}
+
+	doc, _ := destroyAll(c, targets, c.Bool("yes"))
+	printDownSummary(c, doc)
+}
+
+func fleetVmName(region string) string {
+	return EASYVPN_IDENTIFIER + "-" + region
+}
+
+// isFleetVm checks if a vm name belongs to the fleet, optionally restricted to the given regions
+func isFleetVm(name string, regions []string) bool {
+	if len(regions) == 0 {
+		return strings.HasPrefix(name, EASYVPN_IDENTIFIER+"-")
+	}
+	for _, region := range regions {
+		if name == fleetVmName(region) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRegions(value string) (regions []string) {
+	for _, region := range strings.Split(value, ",") {
+		region = strings.TrimSpace(region)
+		if len(region) > 0 {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// syncWriter serializes writes of several goroutines to the same writer
+type syncWriter struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func (w *syncWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(data)
+}
+
+// prefixWriter writes complete lines only, each of them prefixed
+type prefixWriter struct {
+	writer io.Writer
+	prefix string
+	buffer bytes.Buffer
+}
+
+func (w *prefixWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := w.buffer.Next(idx + 1)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if _, err := w.writer.Write(append([]byte(w.prefix), line...)); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
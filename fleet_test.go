@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Fleet_ParseRegions(t *testing.T) {
+	assert.Equal(t, []string{"nyc3", "ams3", "sgp1"}, parseRegions("nyc3,ams3, sgp1"))
+	assert.Equal(t, []string{"nyc3"}, parseRegions("nyc3,,"))
+	assert.Nil(t, parseRegions(""))
+}
+
+func Test_Fleet_IsFleetVm(t *testing.T) {
+	assert.True(t, isFleetVm("easy-vpn-nyc3", nil))
+	assert.True(t, isFleetVm("easy-vpn-ams3", []string{"nyc3", "ams3"}))
+	assert.False(t, isFleetVm("easy-vpn-sgp1", []string{"nyc3", "ams3"}))
+	assert.False(t, isFleetVm("easy-vpn", nil))
+	assert.False(t, isFleetVm("mockName", nil))
+}
+
+func Test_Fleet_PrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{writer: &syncWriter{writer: &out}, prefix: "[nyc3] "}
+
+	fmt.Fprintf(w, "Virtual machine installation")
+	assert.Equal(t, "", out.String())
+
+	fmt.Fprintf(w, "\nVirtual machine is active\n\n")
+	assert.Equal(t, "[nyc3] Virtual machine installation\n[nyc3] Virtual machine is active\n", out.String())
+}
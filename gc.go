@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/ssh"
+	"github.com/codegangsta/cli"
+)
+
+type garbage struct {
+	Kind   string // "vm" or "ssh-key"
+	Id     string
+	Name   string
+	Reason string
+	VM     provider.VM // the stray virtual machine itself, its IP is needed to forget its host key
+}
+
+func collectGarbage(c *cli.Context) {
+	e := getEngine(c)
+	p := e.Provider
+	out := messages(c)
+
+	grace := time.Hour
+	if c.IsSet("grace") {
+		var err error
+		grace, err = time.ParseDuration(c.String("grace"))
+		if err != nil {
+			fail(c, fmt.Errorf("Invalid value for --grace option given: %v", c.String("grace")))
+		}
+	}
+
+	machines, err := e.Show(context.Background())
+	if err != nil {
+		fail(c, err)
+	}
+	keys, err := p.GetInstalledSshKeys()
+	if err != nil {
+		fail(c, fmt.Errorf("Could not retrieve list of installed SSH-Keys: %v", err))
+	}
+
+	// ask every active easy-vpn vm about its recorded deadline and provisioning state
+	statuses := make(map[string]easyvpn.Status)
+	for _, machine := range machines {
+		if !easyvpn.IsEasyVpn(machine.Name) || machine.Status != "active" {
+			continue
+		}
+		status, err := e.StatusOf(context.Background(), machine)
+		if err != nil {
+			fmt.Fprintf(out, "Skipping unreachable virtual machine [%s]: %v\n", machine.Name, err)
+			continue
+		}
+		statuses[machine.Id] = status
+	}
+
+	strays := findStrayVMs(machines, statuses, time.Now(), grace)
+	orphans := findOrphanedKeys(keys, machines, strays)
+	if len(strays)+len(orphans) == 0 {
+		fmt.Fprintln(out, "Nothing to clean up")
+		return
+	}
+
+	fmt.Println("=========================================================================")
+	for _, item := range append(strays, orphans...) {
+		fmt.Fprintf(writer, "%s: %s\tId: %s\tReason: %s\n", item.Kind, item.Name, item.Id, item.Reason)
+	}
+	writer.Flush()
+	fmt.Println("=========================================================================")
+
+	if c.Bool("dry-run") {
+		return
+	}
+	if !c.Bool("yes") {
+		fmt.Fprintln(out, "Do you really want to delete all of the above?")
+		if !confirm(c) {
+			return
+		}
+	}
+
+	failed := false
+	for _, item := range strays {
+		fmt.Fprintf(out, "Destroy virtual machine [%s]\n", item.Name)
+		if err := e.Destroy(context.Background(), item.VM); err != nil {
+			fmt.Fprintf(out, "Could not destroy virtual machine [%s]: %v\n", item.Name, err)
+			failed = true
+		}
+	}
+	for _, item := range orphans {
+		fmt.Fprintf(out, "Delete SSH-Key [%s]\n", item.Name)
+		deleteKey := func() error { return p.DeleteSshKey(item.Id) }
+		if vmName, ephemeral := ssh.IsEphemeralKey(item.Name); ephemeral {
+			// its private key file goes as well
+			deleteKey = func() error { return ssh.DeleteEphemeralKey(p, vmName) }
+		}
+		if err := deleteKey(); err != nil {
+			fmt.Fprintf(out, "Could not delete SSH-Key [%s]: %v\n", item.Name, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// findStrayVMs returns easy-vpn vm's which outlived their self-destruct deadline or never finished provisioning,
+// vm's younger than the grace period are left alone since they might still be in the middle of an "up"
+func findStrayVMs(machines []provider.VM, statuses map[string]easyvpn.Status, now time.Time, grace time.Duration) (strays []garbage) {
+	for _, machine := range machines {
+		if !easyvpn.IsEasyVpn(machine.Name) {
+			continue
+		}
+		if !machine.Created.IsZero() && now.Sub(machine.Created) < grace {
+			continue
+		}
+
+		item := garbage{Kind: "vm", Id: machine.Id, Name: machine.Name, VM: machine}
+		if machine.Status != "active" {
+			item.Reason = fmt.Sprintf("provisioning never completed, status is [%s]", machine.Status)
+			strays = append(strays, item)
+			continue
+		}
+
+		status, ok := statuses[machine.Id]
+		if !ok {
+			continue
+		}
+		if !status.Deadline.IsZero() && status.Deadline.Before(now) {
+			item.Reason = fmt.Sprintf("past its self-destruct deadline of %s", status.Deadline.Local().Format(time.RFC1123))
+			strays = append(strays, item)
+		} else if status.Deadline.IsZero() && !status.VpnRunning {
+			item.Reason = "provisioning never completed, no self-destruct and no VPN running"
+			strays = append(strays, item)
+		}
+	}
+	return strays
+}
+
+// findOrphanedKeys returns easy-vpn ssh-keys which are not needed by any remaining easy-vpn vm,
+// including duplicates of the shared easy-vpn key left behind by failed runs
+func findOrphanedKeys(keys []provider.SshKey, machines []provider.VM, strays []garbage) (orphans []garbage) {
+	remaining := make(map[string]bool)
+	for _, machine := range machines {
+		if easyvpn.IsEasyVpn(machine.Name) {
+			remaining[machine.Name] = true
+		}
+	}
+	for _, stray := range strays {
+		delete(remaining, stray.Name)
+	}
+
+	sharedKeyInUse := false
+	for _, key := range keys {
+		item := garbage{Kind: "ssh-key", Id: key.Id, Name: key.Name}
+		if vmName, ephemeral := ssh.IsEphemeralKey(key.Name); ephemeral {
+			if easyvpn.IsEasyVpn(vmName) && !remaining[vmName] {
+				item.Reason = "its virtual machine does not exist anymore"
+				orphans = append(orphans, item)
+			}
+			continue
+		}
+		if key.Name != EASYVPN_IDENTIFIER && !strings.HasPrefix(key.Name, EASYVPN_IDENTIFIER+"-") {
+			continue
+		}
+
+		switch {
+		case key.Name == EASYVPN_IDENTIFIER && len(remaining) > 0 && !sharedKeyInUse:
+			// the first one is what ssh.EasyVpnKeyId would pick
+			sharedKeyInUse = true
+		case key.Name == EASYVPN_IDENTIFIER && len(remaining) > 0:
+			item.Reason = "duplicate of the easy-vpn SSH-Key"
+			orphans = append(orphans, item)
+		case key.Name == EASYVPN_IDENTIFIER:
+			item.Reason = "no easy-vpn virtual machine left"
+			orphans = append(orphans, item)
+		case !remaining[key.Name]:
+			item.Reason = "its virtual machine does not exist anymore"
+			orphans = append(orphans, item)
+		}
+	}
+	return orphans
+}
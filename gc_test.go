@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GC_FindStrayVMs(t *testing.T) {
+	now := time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC)
+	machines := []provider.VM{
+		provider.VM{Id: "1", Name: "easy-vpn", IP: "198.51.100.1", Status: "active", Created: now.Add(-7 * time.Hour)},
+		provider.VM{Id: "2", Name: "easy-vpn-ams3", Status: "new", Created: now.Add(-2 * time.Hour)},
+		provider.VM{Id: "3", Name: "easy-vpn-sgp1", Status: "new", Created: now.Add(-10 * time.Minute)},
+		provider.VM{Id: "4", Name: "easy-vpn-nyc3", Status: "active", Created: now.Add(-3 * time.Hour)},
+		provider.VM{Id: "5", Name: "easy-vpn-lon1", Status: "active", Created: now.Add(-3 * time.Hour)},
+		provider.VM{Id: "6", Name: "mockName", Status: "new", Created: now.Add(-9 * time.Hour)},
+	}
+	statuses := map[string]easyvpn.Status{
+		"1": easyvpn.Status{Deadline: now.Add(-1 * time.Hour), Watchdog: true, VpnRunning: true},
+		"4": easyvpn.Status{},
+		"5": easyvpn.Status{Deadline: now.Add(3 * time.Hour), Watchdog: true, VpnRunning: true},
+	}
+
+	strays := findStrayVMs(machines, statuses, now, time.Hour)
+	if assert.Equal(t, 3, len(strays)) {
+		assert.Equal(t, "1", strays[0].Id)
+		assert.Equal(t, "198.51.100.1", strays[0].VM.IP)
+		assert.Contains(t, strays[0].Reason, "deadline")
+		assert.Equal(t, "2", strays[1].Id)
+		assert.Contains(t, strays[1].Reason, "never completed")
+		assert.Equal(t, "4", strays[2].Id)
+		assert.Contains(t, strays[2].Reason, "never completed")
+	}
+}
+
+func Test_GC_FindOrphanedKeys(t *testing.T) {
+	keys := []provider.SshKey{
+		provider.SshKey{Id: "k1", Name: "easy-vpn"},
+		provider.SshKey{Id: "k2", Name: "easy-vpn"},
+		provider.SshKey{Id: "k3", Name: "easy-vpn-ams3"},
+		provider.SshKey{Id: "k4", Name: "mockName"},
+		provider.SshKey{Id: "k5", Name: "easy-vpn.ephemeral"},
+		provider.SshKey{Id: "k6", Name: "easy-vpn-fra1.ephemeral"},
+		provider.SshKey{Id: "k7", Name: "mockName.ephemeral"},
+	}
+	machines := []provider.VM{
+		provider.VM{Id: "1", Name: "easy-vpn"},
+	}
+
+	orphans := findOrphanedKeys(keys, machines, nil)
+	if assert.Equal(t, 3, len(orphans)) {
+		assert.Equal(t, "k2", orphans[0].Id)
+		assert.Equal(t, "k3", orphans[1].Id)
+		assert.Equal(t, "k6", orphans[2].Id)
+	}
+
+	// once the last vm is gone as well, no easy-vpn key is needed anymore
+	orphans = findOrphanedKeys(keys, machines, []garbage{garbage{Kind: "vm", Id: "1", Name: "easy-vpn"}})
+	if assert.Equal(t, 5, len(orphans)) {
+		assert.Equal(t, "k1", orphans[0].Id)
+		assert.Equal(t, "k2", orphans[1].Id)
+		assert.Equal(t, "k3", orphans[2].Id)
+		assert.Equal(t, "k5", orphans[3].Id)
+		assert.Equal(t, "k6", orphans[4].Id)
+	}
+}
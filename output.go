@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+)
+
+type vmDocument struct {
+	Id      string `json:"id" yaml:"id"`
+	Name    string `json:"name" yaml:"name"`
+	IP      string `json:"ip" yaml:"ip"`
+	OS      string `json:"os" yaml:"os"`
+	Region  string `json:"region" yaml:"region"`
+	Status  string `json:"status" yaml:"status"`
+	Created string `json:"created,omitempty" yaml:"created,omitempty"`
+}
+
+type showDocument struct {
+	VMs []vmDocument `json:"vms" yaml:"vms"`
+}
+
+type statusesDocument struct {
+	Statuses []statusDocument `json:"statuses" yaml:"statuses"`
+}
+
+type statusDocument struct {
+	VM               vmDocument `json:"vm" yaml:"vm"`
+	Deadline         string     `json:"deadline,omitempty" yaml:"deadline,omitempty"`
+	RemainingSeconds int64      `json:"remaining_seconds" yaml:"remaining_seconds"`
+	Watchdog         bool       `json:"watchdog" yaml:"watchdog"`
+	VpnRunning       bool       `json:"vpn_running" yaml:"vpn_running"`
+	Clients          int        `json:"clients" yaml:"clients"`
+	BytesIn          uint64     `json:"bytes_in" yaml:"bytes_in"`
+	BytesOut         uint64     `json:"bytes_out" yaml:"bytes_out"`
+	Load             string     `json:"load,omitempty" yaml:"load,omitempty"`
+	Error            string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type upDocument struct {
+	VM          vmDocument          `json:"vm" yaml:"vm"`
+	Endpoint    endpointDocument    `json:"endpoint" yaml:"endpoint"`
+	Credentials credentialsDocument `json:"credentials" yaml:"credentials"`
+	Deadline    string              `json:"deadline,omitempty" yaml:"deadline,omitempty"`
+}
+
+type endpointDocument struct {
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+}
+
+type credentialsDocument struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+type errorDocument struct {
+	Error string `json:"error" yaml:"error"`
+}
+
+func newVmDocument(machine provider.VM) vmDocument {
+	doc := vmDocument{
+		Id:     machine.Id,
+		Name:   machine.Name,
+		IP:     machine.IP,
+		OS:     machine.OS,
+		Region: machine.Region,
+		Status: machine.Status,
+	}
+	if !machine.Created.IsZero() {
+		doc.Created = machine.Created.UTC().Format(time.RFC3339)
+	}
+	return doc
+}
+
+func newStatusDocument(status easyvpn.Status) statusDocument {
+	doc := statusDocument{
+		VM:               newVmDocument(status.VM),
+		RemainingSeconds: int64(status.Remaining / time.Second),
+		Watchdog:         status.Watchdog,
+		VpnRunning:       status.VpnRunning,
+		Clients:          status.Clients,
+		BytesIn:          status.BytesIn,
+		BytesOut:         status.BytesOut,
+		Load:             status.Load,
+	}
+	if !status.Deadline.IsZero() {
+		doc.Deadline = status.Deadline.UTC().Format(time.RFC3339)
+	}
+	if status.Err != nil {
+		doc.Error = status.Err.Error()
+	}
+	return doc
+}
+
+func newUpDocument(deployment *easyvpn.Deployment) upDocument {
+	doc := upDocument{
+		VM:          newVmDocument(deployment.VM),
+		Endpoint:    endpointDocument{Protocol: deployment.Protocol, Host: deployment.VM.IP, Port: deployment.Port},
+		Credentials: credentialsDocument{Username: deployment.Username, Password: deployment.Password},
+	}
+	if !deployment.Deadline.IsZero() {
+		doc.Deadline = deployment.Deadline.UTC().Format(time.RFC3339)
+	}
+	return doc
+}
+
+func outputFormat(c *cli.Context) string {
+	format := strings.ToLower(c.GlobalString("output"))
+	if len(format) == 0 {
+		return OUTPUT_TABLE
+	}
+	return format
+}
+
+func isStructuredOutput(c *cli.Context) bool {
+	format := outputFormat(c)
+	return format == OUTPUT_JSON || format == OUTPUT_YAML
+}
+
+// messages returns where human readable progress messages should go,
+// which must not be stdout if it is reserved for a structured document
+func messages(c *cli.Context) io.Writer {
+	if isStructuredOutput(c) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func printDocument(c *cli.Context, doc interface{}) {
+	if err := writeDocument(os.Stdout, outputFormat(c), doc); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeDocument(w io.Writer, format string, doc interface{}) error {
+	switch format {
+	case OUTPUT_JSON:
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case OUTPUT_YAML:
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(w, string(data))
+		return err
+	}
+	return fmt.Errorf("Unknown output format: %s", format)
+}
+
+// fail terminates easy-vpn with a non-zero exit code, reporting the error in the selected output format
+func fail(c *cli.Context, err error) {
+	if isStructuredOutput(c) {
+		printDocument(c, errorDocument{Error: err.Error()})
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
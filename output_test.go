@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Output_WriteDocument_JSON(t *testing.T) {
+	var out bytes.Buffer
+	doc := showDocument{VMs: []vmDocument{newVmDocument(provider.VM{Id: "mockId", Name: "easy-vpn"})}}
+
+	if assert.Nil(t, writeDocument(&out, OUTPUT_JSON, doc)) {
+		assert.Contains(t, out.String(), `"vms": [`)
+		assert.Contains(t, out.String(), `"id": "mockId"`)
+		assert.Contains(t, out.String(), `"name": "easy-vpn"`)
+	}
+}
+
+func Test_Output_WriteDocument_YAML(t *testing.T) {
+	var out bytes.Buffer
+	doc := errorDocument{Error: "something went wrong"}
+
+	if assert.Nil(t, writeDocument(&out, OUTPUT_YAML, doc)) {
+		assert.Equal(t, "error: something went wrong\n", out.String())
+	}
+}
+
+func Test_Output_WriteDocument_Unknown(t *testing.T) {
+	var out bytes.Buffer
+	assert.NotNil(t, writeDocument(&out, "xml", errorDocument{}))
+	assert.Equal(t, "", out.String())
+}
+
+func Test_Output_UpDocument(t *testing.T) {
+	var out bytes.Buffer
+	doc := newUpDocument(&easyvpn.Deployment{VM: provider.VM{Id: "mockId", Name: "easy-vpn"}, Protocol: "pptp", Port: 1723})
+
+	if assert.Nil(t, writeDocument(&out, OUTPUT_JSON, doc)) {
+		assert.Contains(t, out.String(), `"protocol": "pptp"`)
+		assert.NotContains(t, out.String(), "deadline")
+	}
+}
@@ -2,11 +2,13 @@ package digitalocean
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,12 +34,31 @@ type Droplets struct {
 }
 
 type Droplet struct {
-	Id     int      `json:"id"`
-	Name   string   `json:"name"`
-	Status string   `json:"status"`
-	Region Region   `json:"region"`
-	OS     Image    `json:"image"`
-	IP     Networks `json:"networks"`
+	Id      int      `json:"id"`
+	Name    string   `json:"name"`
+	Status  string   `json:"status"`
+	Region  Region   `json:"region"`
+	OS      Image    `json:"image"`
+	IP      Networks `json:"networks"`
+	Created string   `json:"created_at"`
+}
+
+func (droplet Droplet) toVM() provider.VM {
+	vm := provider.VM{
+		Id:     fmt.Sprintf("%d", droplet.Id),
+		Name:   droplet.Name,
+		Status: droplet.Status,
+		OS:     droplet.OS.Slug,
+		Region: droplet.Region.Slug,
+	}
+	// new droplets do not have a network yet
+	if len(droplet.IP.V4) > 0 {
+		vm.IP = droplet.IP.V4[0].IP
+	}
+	if created, err := time.Parse(time.RFC3339, droplet.Created); err == nil {
+		vm.Created = created
+	}
+	return vm
 }
 
 type Region struct {
@@ -63,6 +84,7 @@ type NetworkV4 struct {
 
 type DO struct {
 	Config *config.Config
+	ctx    context.Context
 }
 
 func (d DO) GetProviderName() string {
@@ -73,6 +95,11 @@ func (d DO) GetConfig() *config.Config {
 	return d.Config
 }
 
+func (d DO) WithContext(ctx context.Context) provider.API {
+	d.ctx = ctx
+	return d
+}
+
 func (d DO) GetInstalledSshKeys() (data []provider.SshKey, err error) {
 	resp, err := d.doGet(baseUrl + `/account/keys`)
 	if err != nil {
@@ -141,7 +168,7 @@ func (d DO) InstallNewSshKey(name, key string) (string, error) {
 func (d DO) UpdateSshKey(id, name, key string) (string, error) {
 	// digitalocean has no "update" command, only delete and create
 	// first we must destroy/delete the existing key
-	err := d.deleteSshKey(id)
+	err := d.DeleteSshKey(id)
 	if err != nil {
 		return "", err
 	}
@@ -150,6 +177,25 @@ func (d DO) UpdateSshKey(id, name, key string) (string, error) {
 	return d.InstallNewSshKey(name, key)
 }
 
+func (d DO) DeleteSshKey(id string) error {
+	resp, err := d.doDelete(baseUrl + `/account/keys/` + id)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.New(string(body))
+	}
+
+	return nil
+}
+
 func (d DO) GetAllVMs() (data []provider.VM, err error) {
 	resp, err := d.doGet(baseUrl + `/droplets`)
 	if err != nil {
@@ -173,21 +219,43 @@ func (d DO) GetAllVMs() (data []provider.VM, err error) {
 
 	// convert digitalocean droplets into array of provider api vm's
 	for _, droplet := range droplets.Droplets {
-		key := provider.VM{
-			Id:     fmt.Sprintf("%d", droplet.Id),
-			Name:   droplet.Name,
-			Status: droplet.Status,
-			OS:     droplet.OS.Slug,
-			IP:     droplet.IP.V4[0].IP,
-			Region: droplet.Region.Slug,
-		}
-		data = append(data, key)
+		data = append(data, droplet.toVM())
 	}
 
 	return data, nil
 }
 
-func (d DO) CreateVM(name, os, size, region, sshkey string) (string, error) {
+func (d DO) GetVM(id string) (provider.VM, error) {
+	resp, err := d.doGet(baseUrl + `/droplets/` + id)
+	if err != nil {
+		return provider.VM{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return provider.VM{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return provider.VM{}, errors.New(string(body))
+	}
+
+	result := struct {
+		Droplet Droplet `json:"droplet"`
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return provider.VM{}, err
+	}
+
+	return result.Droplet.toVM(), nil
+}
+
+func (d DO) CreateVM(name, os, size, region, sshkey, userData string) (string, error) {
+	data := []byte("null")
+	if len(userData) > 0 {
+		data, _ = json.Marshal(userData)
+	}
 	values := fmt.Sprintf(`{
 			"name": "%v",
 			"region": "%v",
@@ -196,9 +264,9 @@ func (d DO) CreateVM(name, os, size, region, sshkey string) (string, error) {
 			"ssh_keys": ["%v"],
 			"backups": false,
 			"ipv6": false,
-			"user_data": null,
+			"user_data": %s,
 			"private_networking": null
-		}`, name, region, size, os, sshkey)
+		}`, name, region, size, os, sshkey, data)
 	resp, err := d.doPost(baseUrl+`/droplets`, values)
 	if err != nil {
 		return "", err
@@ -274,12 +342,124 @@ func (d DO) DestroyVM(id string) error {
 	return nil
 }
 
-func (d DO) Sleep() {
-	time.Sleep(time.Duration(d.GetConfig().Sleep) * time.Millisecond)
+type Firewall struct {
+	Id            string         `json:"id,omitempty"`
+	Name          string         `json:"name"`
+	InboundRules  []FirewallRule `json:"inbound_rules,omitempty"`
+	OutboundRules []FirewallRule `json:"outbound_rules,omitempty"`
+	DropletIds    []int          `json:"droplet_ids,omitempty"`
 }
 
-func (d DO) deleteSshKey(id string) error {
-	resp, err := d.doDelete(baseUrl + `/account/keys/` + id)
+type FirewallRule struct {
+	Protocol     string     `json:"protocol"`
+	Ports        string     `json:"ports,omitempty"`
+	Sources      *Addresses `json:"sources,omitempty"`
+	Destinations *Addresses `json:"destinations,omitempty"`
+}
+
+type Addresses struct {
+	Addresses []string `json:"addresses"`
+}
+
+var everybody = &Addresses{[]string{"0.0.0.0/0", "::/0"}}
+
+func (d DO) GetFirewalls() (data []provider.Firewall, err error) {
+	resp, err := d.doGet(baseUrl + `/firewalls`)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(body))
+	}
+
+	result := struct {
+		Firewalls []Firewall `json:"firewalls"`
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	for _, firewall := range result.Firewalls {
+		data = append(data, provider.Firewall{Id: firewall.Id, Name: firewall.Name})
+	}
+	return data, nil
+}
+
+// CreateFirewall creates a cloud firewall for a droplet, which allows all outgoing traffic.
+// DigitalOcean firewalls only know tcp, udp and icmp, and would block GRE and with it PPTP.
+func (d DO) CreateFirewall(name, vmId string, rules []provider.FirewallRule) (string, error) {
+	dropletId, err := strconv.Atoi(vmId)
+	if err != nil {
+		return "", fmt.Errorf("Invalid droplet id: %v", vmId)
+	}
+
+	firewall := Firewall{
+		Name:       name,
+		DropletIds: []int{dropletId},
+		OutboundRules: []FirewallRule{
+			{Protocol: "tcp", Ports: "all", Destinations: everybody},
+			{Protocol: "udp", Ports: "all", Destinations: everybody},
+			{Protocol: "icmp", Destinations: everybody},
+		},
+	}
+	for _, rule := range rules {
+		switch rule.Protocol {
+		case "tcp", "udp", "icmp":
+		default:
+			return "", provider.ErrFirewallUnsupported
+		}
+
+		inbound := FirewallRule{Protocol: rule.Protocol, Sources: everybody}
+		if len(rule.Sources) > 0 {
+			inbound.Sources = &Addresses{rule.Sources}
+		}
+		if rule.Protocol != "icmp" {
+			inbound.Ports = rule.Port
+			if len(inbound.Ports) == 0 {
+				inbound.Ports = "all"
+			}
+		}
+		firewall.InboundRules = append(firewall.InboundRules, inbound)
+	}
+
+	values, err := json.Marshal(firewall)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.doPost(baseUrl+`/firewalls`, string(values))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		return "", errors.New(string(body))
+	}
+
+	result := struct {
+		Firewall Firewall `json:"firewall"`
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+
+	return result.Firewall.Id, nil
+}
+
+func (d DO) DeleteFirewall(id string) error {
+	resp, err := d.doDelete(baseUrl + `/firewalls/` + id)
 	if err != nil {
 		return err
 	}
@@ -297,11 +477,18 @@ func (d DO) deleteSshKey(id string) error {
 	return nil
 }
 
+func (d DO) Sleep() {
+	select {
+	case <-time.After(time.Duration(d.GetConfig().Sleep) * time.Millisecond):
+	case <-d.context().Done():
+	}
+}
+
 func (d *DO) doGet(url string) (*http.Response, error) {
 	cfg := d.GetConfig()
 	client := &http.Client{}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(d.context(), "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -315,7 +502,7 @@ func (d *DO) doPost(url, data string) (*http.Response, error) {
 	cfg := d.GetConfig()
 	client := &http.Client{}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(data)))
+	req, err := http.NewRequestWithContext(d.context(), "POST", url, bytes.NewBuffer([]byte(data)))
 	if err != nil {
 		return nil, err
 	}
@@ -330,7 +517,7 @@ func (d *DO) doDelete(url string) (*http.Response, error) {
 	cfg := d.GetConfig()
 	client := &http.Client{}
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(d.context(), "DELETE", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -339,3 +526,10 @@ func (d *DO) doDelete(url string) (*http.Response, error) {
 	d.Sleep() // respect request rate limitation
 	return client.Do(req)
 }
+
+func (d *DO) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
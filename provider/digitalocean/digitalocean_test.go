@@ -1,13 +1,17 @@
 package digitalocean
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -155,7 +159,7 @@ func Test_Provider_Digitalocean_DeleteSshKey_Error(t *testing.T) {
 
 	d := DO{Config: testConfig}
 
-	err := d.deleteSshKey("123")
+	err := d.DeleteSshKey("123")
 	if assert.NotNil(t, err) {
 		assert.Equal(t, `{error-message}`, err.Error())
 	}
@@ -167,7 +171,7 @@ func Test_Provider_Digitalocean_DeleteSshKey_Deleted(t *testing.T) {
 
 	d := DO{Config: testConfig}
 
-	err := d.deleteSshKey("123")
+	err := d.DeleteSshKey("123")
 	if err != nil {
 		t.Error(err)
 	}
@@ -199,6 +203,21 @@ func Test_Provider_Digitalocean_GetAllVMs_NoVMs(t *testing.T) {
 	assert.Nil(t, machines)
 }
 
+func Test_Provider_Digitalocean_GetAllVMs_Canceled(t *testing.T) {
+	server := getTestServer(http.StatusOK, `[]`)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := DO{Config: testConfig}.WithContext(ctx)
+
+	machines, err := d.GetAllVMs()
+	assert.Nil(t, machines)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), context.Canceled.Error())
+	}
+}
+
 func Test_Provider_Digitalocean_GetAllVMs_VMs(t *testing.T) {
 	server := getTestServer(http.StatusOK, `{"droplets": [{
     "id": 1111,
@@ -206,6 +225,7 @@ func Test_Provider_Digitalocean_GetAllVMs_VMs(t *testing.T) {
     "memory": 512,
     "disk": 20,
     "status": "active",
+    "created_at": "2014-11-14T16:29:21Z",
     "image": {
         "id": 6918990,
         "name": "14.04 x64",
@@ -281,10 +301,12 @@ func Test_Provider_Digitalocean_GetAllVMs_VMs(t *testing.T) {
 				assert.Equal(t, "ubuntu-14-04-x64", vm.OS)
 				assert.Equal(t, "nyc3", vm.Region)
 				assert.Equal(t, "active", vm.Status)
+				assert.Equal(t, time.Date(2014, 11, 14, 16, 29, 21, 0, time.UTC), vm.Created)
 			case "7777":
 				assert.Equal(t, "nyc2", vm.Region)
 				assert.Equal(t, "104.236.32.999", vm.IP)
 				assert.Equal(t, "centos-x64", vm.OS)
+				assert.True(t, vm.Created.IsZero())
 			case "9999":
 				assert.Equal(t, "test.com", vm.Name)
 				assert.Equal(t, "stopped", vm.Status)
@@ -296,13 +318,56 @@ func Test_Provider_Digitalocean_GetAllVMs_VMs(t *testing.T) {
 	}
 }
 
+func Test_Provider_Digitalocean_GetVM_Error(t *testing.T) {
+	server := getTestServer(http.StatusNotFound, `{"id":"not_found","message":"The resource you were accessing could not be found."}`)
+	defer server.Close()
+
+	d := DO{Config: testConfig}
+
+	_, err := d.GetVM("1111")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `not_found`)
+	}
+}
+
+func Test_Provider_Digitalocean_GetVM_VM(t *testing.T) {
+	server := getTestServer(http.StatusOK, `{"droplet": {
+    "id": 1111,
+    "name": "easy-vpn",
+    "status": "new",
+    "created_at": "2014-11-14T16:29:21Z",
+    "image": {
+        "slug": "ubuntu-14-04-x64"
+    },
+    "networks": {"v4": [], "v6": []},
+    "region": {
+        "name": "New York 3",
+        "slug": "nyc3"
+    }
+}}`)
+	defer server.Close()
+
+	d := DO{Config: testConfig}
+
+	vm, err := d.GetVM("1111")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "1111", vm.Id)
+	assert.Equal(t, "easy-vpn", vm.Name)
+	assert.Equal(t, "new", vm.Status)
+	assert.Equal(t, "", vm.IP)
+	assert.Equal(t, "nyc3", vm.Region)
+	assert.Equal(t, time.Date(2014, 11, 14, 16, 29, 21, 0, time.UTC), vm.Created)
+}
+
 func Test_Provider_Digitalocean_CreateVM_Error(t *testing.T) {
 	server := getTestServer(http.StatusNotAcceptable, `{error-message}`)
 	defer server.Close()
 
 	d := DO{Config: testConfig}
 
-	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	assert.Equal(t, "", vmId)
 	if assert.NotNil(t, err) {
 		assert.Equal(t, `{error-message}`, err.Error())
@@ -315,7 +380,7 @@ func Test_Provider_Digitalocean_CreateVM_NoVMId(t *testing.T) {
 
 	d := DO{Config: testConfig}
 
-	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	assert.Equal(t, "", vmId)
 	if assert.NotNil(t, err) {
 		assert.Equal(t, `{error-message}`, err.Error())
@@ -328,7 +393,7 @@ func Test_Provider_Digitalocean_CreateVM_VMId(t *testing.T) {
 
 	d := DO{Config: testConfig}
 
-	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := d.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -408,3 +473,60 @@ func Test_Provider_Digitalocean_DestroyVM_VMId(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func Test_Provider_Digitalocean_GetFirewalls(t *testing.T) {
+	server := getTestServer(http.StatusOK,
+		`{"firewalls":[{"id":"fb6045f1-cf1d-4ca3-bfac-18832663025b","name":"easy-vpn.1111","status":"succeeded"}]}`)
+	defer server.Close()
+
+	d := DO{Config: testConfig}
+
+	firewalls, err := d.GetFirewalls()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(firewalls)) {
+		assert.Equal(t, "fb6045f1-cf1d-4ca3-bfac-18832663025b", firewalls[0].Id)
+		assert.Equal(t, "easy-vpn.1111", firewalls[0].Name)
+	}
+}
+
+func Test_Provider_Digitalocean_CreateFirewall(t *testing.T) {
+	var request string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request = string(body)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"firewall":{"id":"fb6045f1-cf1d-4ca3-bfac-18832663025b","name":"easy-vpn.1111"}}`)
+	}))
+	baseUrl = ts.URL
+	defer ts.Close()
+
+	d := DO{Config: testConfig}
+
+	id, err := d.CreateFirewall("easy-vpn.1111", "1111", []provider.FirewallRule{
+		{Protocol: "tcp", Port: "22", Sources: []string{"203.0.113.7/32"}},
+		{Protocol: "udp", Port: "51820"},
+		{Protocol: "icmp"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fb6045f1-cf1d-4ca3-bfac-18832663025b", id)
+	assert.Contains(t, request, `"droplet_ids":[1111]`)
+	assert.Contains(t, request, `{"protocol":"tcp","ports":"22","sources":{"addresses":["203.0.113.7/32"]}}`)
+	assert.Contains(t, request, `{"protocol":"udp","ports":"51820","sources":{"addresses":["0.0.0.0/0","::/0"]}}`)
+	assert.Contains(t, request, `{"protocol":"icmp","sources":{"addresses":["0.0.0.0/0","::/0"]}}`)
+}
+
+func Test_Provider_Digitalocean_CreateFirewall_Unsupported(t *testing.T) {
+	d := DO{Config: testConfig}
+
+	_, err := d.CreateFirewall("easy-vpn.1111", "1111", []provider.FirewallRule{{Protocol: "gre"}})
+	assert.Equal(t, provider.ErrFirewallUnsupported, err)
+}
+
+func Test_Provider_Digitalocean_DeleteFirewall(t *testing.T) {
+	server := getTestServer(http.StatusNoContent, ``)
+	defer server.Close()
+
+	d := DO{Config: testConfig}
+
+	assert.Nil(t, d.DeleteFirewall("fb6045f1-cf1d-4ca3-bfac-18832663025b"))
+}
@@ -1,6 +1,12 @@
 package provider
 
-import "github.com/JamesClonk/easy-vpn/config"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+)
 
 type SshKey struct {
 	Id   string
@@ -9,29 +15,56 @@ type SshKey struct {
 }
 
 type VM struct {
-	Id     string
-	Name   string
-	OS     string
-	IP     string
-	Region string
-	Status string
+	Id      string
+	Name    string
+	OS      string
+	IP      string
+	Region  string
+	Status  string
+	Created time.Time
+}
+
+// ErrFirewallUnsupported is returned by CreateFirewall if the cloud firewall of the provider can not allow some protocol of the rules,
+// as it would block that protocol altogether
+var ErrFirewallUnsupported = errors.New("Cloud firewall does not support all protocols of the rules")
+
+// FirewallRule allows incoming traffic, everything else is dropped by a cloud firewall
+type FirewallRule struct {
+	Protocol string   // "tcp", "udp", "icmp" or "gre"
+	Port     string   // like "22", empty for all ports and for protocols without ports
+	Sources  []string // CIDRs, everybody if empty
+}
+
+type Firewall struct {
+	Id   string
+	Name string
 }
 
 type API interface {
 	GetProviderName() string
 	GetConfig() *config.Config
 
+	// WithContext returns a copy of the provider whose API calls are canceled together with ctx
+	WithContext(ctx context.Context) API
+
 	// ssh-keys
 	GetInstalledSshKeys() ([]SshKey, error)
 	InstallNewSshKey(name, key string) (string, error)
 	UpdateSshKey(id, name, key string) (string, error)
+	DeleteSshKey(id string) error
 
 	// machines
 	GetAllVMs() ([]VM, error)
-	CreateVM(name, os, size, region, sshkey string) (string, error)
+	GetVM(id string) (VM, error)
+	CreateVM(name, os, size, region, sshkey, userData string) (string, error) // userData is a cloud-init config, if not empty
 	StartVM(id string) error
 	DestroyVM(id string) error
 
+	// cloud firewalls
+	GetFirewalls() ([]Firewall, error)
+	CreateFirewall(name, vmId string, rules []FirewallRule) (string, error)
+	DeleteFirewall(id string) error
+
 	// for request rate limiting
 	Sleep()
 }
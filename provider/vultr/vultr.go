@@ -1,12 +1,16 @@
 package vultr
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,16 +27,33 @@ type SshKey struct {
 }
 
 type Server struct {
-	Id     string `json:"SUBID"`
-	Name   string `json:"label"`
-	OS     string `json:"os"`
-	IP     string `json:"main_ip"`
-	Region string `json:"DCID"`
-	Status string `json:"status"`
+	Id      string `json:"SUBID"`
+	Name    string `json:"label"`
+	OS      string `json:"os"`
+	IP      string `json:"main_ip"`
+	Region  string `json:"DCID"`
+	Status  string `json:"status"`
+	Created string `json:"date_created"`
+}
+
+func (server Server) toVM() provider.VM {
+	vm := provider.VM{
+		Id:     server.Id,
+		Name:   server.Name,
+		OS:     server.OS,
+		IP:     server.IP,
+		Region: server.Region,
+		Status: server.Status,
+	}
+	if created, err := time.Parse("2006-01-02 15:04:05", server.Created); err == nil {
+		vm.Created = created
+	}
+	return vm
 }
 
 type Vultr struct {
 	Config *config.Config
+	ctx    context.Context
 }
 
 func (v Vultr) GetProviderName() string {
@@ -43,8 +64,13 @@ func (v Vultr) GetConfig() *config.Config {
 	return v.Config
 }
 
+func (v Vultr) WithContext(ctx context.Context) provider.API {
+	v.ctx = ctx
+	return v
+}
+
 func (v Vultr) GetInstalledSshKeys() (data []provider.SshKey, err error) {
-	resp, err := http.Get(v.urlWithApiKey(baseUrl + `/sshkey/list`))
+	resp, err := v.doGet(v.urlWithApiKey(baseUrl + `/sshkey/list`))
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +110,7 @@ func (v Vultr) GetInstalledSshKeys() (data []provider.SshKey, err error) {
 }
 
 func (v Vultr) InstallNewSshKey(name, key string) (string, error) {
-	resp, err := http.PostForm(v.urlWithApiKey(baseUrl+`/sshkey/create`),
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/sshkey/create`),
 		url.Values{
 			"name":    {name},
 			"ssh_key": {key},
@@ -119,7 +145,7 @@ func (v Vultr) InstallNewSshKey(name, key string) (string, error) {
 }
 
 func (v Vultr) UpdateSshKey(id, name, key string) (string, error) {
-	resp, err := http.PostForm(v.urlWithApiKey(baseUrl+`/sshkey/update`),
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/sshkey/update`),
 		url.Values{
 			"SSHKEYID": {id},
 			"name":     {name},
@@ -143,8 +169,31 @@ func (v Vultr) UpdateSshKey(id, name, key string) (string, error) {
 	return id, nil
 }
 
+func (v Vultr) DeleteSshKey(id string) error {
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/sshkey/destroy`),
+		url.Values{
+			"SSHKEYID": {id},
+		})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(string(body))
+	}
+
+	v.Sleep() // respect request rate limitation
+	return nil
+}
+
 func (v Vultr) GetAllVMs() (data []provider.VM, err error) {
-	resp, err := http.Get(v.urlWithApiKey(baseUrl + `/server/list`))
+	resp, err := v.doGet(v.urlWithApiKey(baseUrl + `/server/list`))
 	if err != nil {
 		return nil, err
 	}
@@ -171,30 +220,55 @@ func (v Vultr) GetAllVMs() (data []provider.VM, err error) {
 
 	// convert vultr servers into array of provider api vm's
 	for _, value := range vultrServers {
-		key := provider.VM{
-			Id:     value.Id,
-			Name:   value.Name,
-			OS:     value.OS,
-			IP:     value.IP,
-			Region: value.Region,
-			Status: value.Status,
-		}
-		data = append(data, key)
+		data = append(data, value.toVM())
 	}
 
 	v.Sleep() // respect request rate limitation
 	return data, nil
 }
 
-func (v Vultr) CreateVM(name, os, size, region, sshkey string) (string, error) {
-	resp, err := http.PostForm(v.urlWithApiKey(baseUrl+`/server/create`),
-		url.Values{
-			"label":     {name},
-			"OSID":      {os},
-			"VPSPLANID": {size},
-			"DCID":      {region},
-			"SSHKEYID":  {sshkey},
-		})
+func (v Vultr) GetVM(id string) (provider.VM, error) {
+	// with a SUBID given vultr returns only this one server instead of a map of all of them
+	resp, err := v.doGet(v.urlWithApiKey(baseUrl+`/server/list`) + `&SUBID=` + url.QueryEscape(id))
+	if err != nil {
+		return provider.VM{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return provider.VM{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return provider.VM{}, errors.New(string(body))
+	}
+
+	if !strings.Contains(string(body), `"SUBID":`) {
+		return provider.VM{}, errors.New(string(body))
+	}
+
+	var server Server
+	if err := json.Unmarshal(body, &server); err != nil {
+		return provider.VM{}, err
+	}
+
+	v.Sleep() // respect request rate limitation
+	return server.toVM(), nil
+}
+
+func (v Vultr) CreateVM(name, os, size, region, sshkey, userData string) (string, error) {
+	values := url.Values{
+		"label":     {name},
+		"OSID":      {os},
+		"VPSPLANID": {size},
+		"DCID":      {region},
+		"SSHKEYID":  {sshkey},
+	}
+	if len(userData) > 0 {
+		values.Set("userdata", base64.StdEncoding.EncodeToString([]byte(userData)))
+	}
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/server/create`), values)
 	if err != nil {
 		return "", err
 	}
@@ -225,7 +299,7 @@ func (v Vultr) CreateVM(name, os, size, region, sshkey string) (string, error) {
 }
 
 func (v Vultr) StartVM(id string) error {
-	resp, err := http.PostForm(v.urlWithApiKey(baseUrl+`/server/start`),
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/server/start`),
 		url.Values{
 			"SUBID": {id},
 		})
@@ -248,7 +322,7 @@ func (v Vultr) StartVM(id string) error {
 }
 
 func (v Vultr) DestroyVM(id string) error {
-	resp, err := http.PostForm(v.urlWithApiKey(baseUrl+`/server/destroy`),
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+`/server/destroy`),
 		url.Values{
 			"SUBID": {id},
 		})
@@ -270,11 +344,169 @@ func (v Vultr) DestroyVM(id string) error {
 	return nil
 }
 
+type FirewallGroup struct {
+	Id          string `json:"FIREWALLGROUPID"`
+	Description string `json:"description"`
+}
+
+func (v Vultr) GetFirewalls() (data []provider.Firewall, err error) {
+	resp, err := v.doGet(v.urlWithApiKey(baseUrl + `/firewall/group_list`))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(body))
+	}
+
+	// vultr returns empty array if no firewall groups are found
+	if strings.Trim(string(body), "\t\r\n ") == "[]" {
+		return data, nil
+	}
+
+	var groups map[string]FirewallGroup
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, err
+	}
+
+	// firewall groups have no name, only a description
+	for _, value := range groups {
+		data = append(data, provider.Firewall{Id: value.Id, Name: value.Description})
+	}
+
+	v.Sleep() // respect request rate limitation
+	return data, nil
+}
+
+// CreateFirewall creates a firewall group with the given rules and attaches the server to it
+func (v Vultr) CreateFirewall(name, vmId string, rules []provider.FirewallRule) (string, error) {
+	body, err := v.post(`/firewall/group_create`, url.Values{"description": {name}})
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(body, `"FIREWALLGROUPID":`) {
+		return "", errors.New(body)
+	}
+	var group FirewallGroup
+	if err := json.Unmarshal([]byte(body), &group); err != nil {
+		return "", err
+	}
+
+	if err := v.createFirewallRules(group.Id, rules); err != nil {
+		v.DeleteFirewall(group.Id)
+		return "", err
+	}
+	if _, err := v.post(`/server/firewall_group_set`, url.Values{"SUBID": {vmId}, "FIREWALLGROUPID": {group.Id}}); err != nil {
+		v.DeleteFirewall(group.Id)
+		return "", err
+	}
+	return group.Id, nil
+}
+
+func (v Vultr) createFirewallRules(groupId string, rules []provider.FirewallRule) error {
+	for _, rule := range rules {
+		sources := rule.Sources
+		if len(sources) == 0 {
+			sources = []string{"0.0.0.0/0", "::/0"}
+		}
+		for _, source := range sources {
+			_, subnet, err := net.ParseCIDR(source)
+			if err != nil {
+				return fmt.Errorf("Invalid CIDR: %v", source)
+			}
+			size, _ := subnet.Mask.Size()
+			ipType := "v4"
+			if subnet.IP.To4() == nil {
+				ipType = "v6"
+			}
+
+			values := url.Values{
+				"FIREWALLGROUPID": {groupId},
+				"direction":       {"in"},
+				"ip_type":         {ipType},
+				"protocol":        {rule.Protocol},
+				"subnet":          {subnet.IP.String()},
+				"subnet_size":     {strconv.Itoa(size)},
+			}
+			if rule.Protocol == "tcp" || rule.Protocol == "udp" {
+				port := rule.Port
+				if len(port) == 0 {
+					port = "1:65535"
+				}
+				values.Set("port", port)
+			}
+			if _, err := v.post(`/firewall/rule_create`, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v Vultr) DeleteFirewall(id string) error {
+	_, err := v.post(`/firewall/group_delete`, url.Values{"FIREWALLGROUPID": {id}})
+	return err
+}
+
+// post calls an API endpoint and returns the response body, or an error if the call did not succeed
+func (v Vultr) post(path string, values url.Values) (string, error) {
+	resp, err := v.doPostForm(v.urlWithApiKey(baseUrl+path), values)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(string(body))
+	}
+
+	v.Sleep() // respect request rate limitation
+	return string(body), nil
+}
+
 func (v Vultr) Sleep() {
-	time.Sleep(time.Duration(v.GetConfig().Sleep) * time.Millisecond)
+	select {
+	case <-time.After(time.Duration(v.GetConfig().Sleep) * time.Millisecond):
+	case <-v.context().Done():
+	}
 }
 
 func (v *Vultr) urlWithApiKey(url string) string {
 	cfg := v.GetConfig()
 	return fmt.Sprintf("%v?api_key=%v", url, cfg.Providers[cfg.Provider].ApiKey)
 }
+
+func (v *Vultr) doGet(url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(v.context(), "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (v *Vultr) doPostForm(url string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(v.context(), "POST", url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return http.DefaultClient.Do(req)
+}
+
+func (v *Vultr) context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
@@ -1,13 +1,16 @@
 package vultr
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -174,6 +177,30 @@ func Test_Provider_Vultr_UpdateSshKey_KeyId(t *testing.T) {
 	}
 }
 
+func Test_Provider_Vultr_DeleteSshKey_Error(t *testing.T) {
+	server := getTestServer(http.StatusNotAcceptable, `{error-message}`)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	err := v.DeleteSshKey("o1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `{error-message}`, err.Error())
+	}
+}
+
+func Test_Provider_Vultr_DeleteSshKey_Deleted(t *testing.T) {
+	server := getTestServer(http.StatusOK, ``)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	err := v.DeleteSshKey("o1")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func Test_Provider_Vultr_GetAllVMs_Error(t *testing.T) {
 	server := getTestServer(http.StatusNotAcceptable, `{error-message}`)
 	defer server.Close()
@@ -200,11 +227,26 @@ func Test_Provider_Vultr_GetAllVMs_NoVMs(t *testing.T) {
 	assert.Nil(t, machines)
 }
 
+func Test_Provider_Vultr_GetAllVMs_Canceled(t *testing.T) {
+	server := getTestServer(http.StatusOK, `[]`)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v := Vultr{Config: testConfig}.WithContext(ctx)
+
+	machines, err := v.GetAllVMs()
+	assert.Nil(t, machines)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), context.Canceled.Error())
+	}
+}
+
 func Test_Provider_Vultr_GetAllVMs_VMs(t *testing.T) {
 	server := getTestServer(http.StatusOK,
 		`{
 			"1":{"SUBID":"1","label":"alpha","OS":"ubuntu","main_ip":"123.456.789.0"},
-			"2":{"SUBID":"2","label":"beta","OS":"ubuntu","DCID":"Earth","status":"active"},
+			"2":{"SUBID":"2","label":"beta","OS":"ubuntu","DCID":"Earth","status":"active","date_created":"2013-12-19 14:45:41"},
 			"3":{"SUBID":"3","label":"charlie","OS":"centos"}
 		}`)
 	defer server.Close()
@@ -227,6 +269,7 @@ func Test_Provider_Vultr_GetAllVMs_VMs(t *testing.T) {
 			case "2":
 				assert.Equal(t, "Earth", vm.Region)
 				assert.Equal(t, "active", vm.Status)
+				assert.Equal(t, time.Date(2013, 12, 19, 14, 45, 41, 0, time.UTC), vm.Created)
 			case "3":
 				assert.Equal(t, "charlie", vm.Name)
 				assert.Equal(t, "centos", vm.OS)
@@ -237,13 +280,43 @@ func Test_Provider_Vultr_GetAllVMs_VMs(t *testing.T) {
 	}
 }
 
+func Test_Provider_Vultr_GetVM_Error(t *testing.T) {
+	server := getTestServer(http.StatusOK, `[]`)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	_, err := v.GetVM("1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `[]`, err.Error())
+	}
+}
+
+func Test_Provider_Vultr_GetVM_VM(t *testing.T) {
+	server := getTestServer(http.StatusOK,
+		`{"SUBID":"2","label":"easy-vpn","os":"Ubuntu 14.04 x64","main_ip":"0","DCID":"1","status":"pending","date_created":"2013-12-19 14:45:41"}`)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	vm, err := v.GetVM("2")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "2", vm.Id)
+	assert.Equal(t, "easy-vpn", vm.Name)
+	assert.Equal(t, "pending", vm.Status)
+	assert.Equal(t, "1", vm.Region)
+	assert.Equal(t, time.Date(2013, 12, 19, 14, 45, 41, 0, time.UTC), vm.Created)
+}
+
 func Test_Provider_Vultr_CreateVM_Error(t *testing.T) {
 	server := getTestServer(http.StatusNotAcceptable, `{error-message}`)
 	defer server.Close()
 
 	v := Vultr{Config: testConfig}
 
-	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	assert.Equal(t, "", vmId)
 	if assert.NotNil(t, err) {
 		assert.Equal(t, `{error-message}`, err.Error())
@@ -256,7 +329,7 @@ func Test_Provider_Vultr_CreateVM_NoVMId(t *testing.T) {
 
 	v := Vultr{Config: testConfig}
 
-	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	assert.Equal(t, "", vmId)
 	if assert.NotNil(t, err) {
 		assert.Equal(t, `{error-message}`, err.Error())
@@ -269,7 +342,7 @@ func Test_Provider_Vultr_CreateVM_VMId(t *testing.T) {
 
 	v := Vultr{Config: testConfig}
 
-	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id")
+	vmId, err := v.CreateVM("test-vm", "test-os", "test-size", "test-region", "test-key-id", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -333,3 +406,92 @@ func Test_Provider_Vultr_UrlWithApiKey(t *testing.T) {
 		assert.Equal(t, "http://localhost/base?api_key=xyzabcdefg999", url)
 	}
 }
+
+func Test_Provider_Vultr_GetFirewalls(t *testing.T) {
+	server := getTestServer(http.StatusOK,
+		`{"1234abcd":{"FIREWALLGROUPID":"1234abcd","description":"easy-vpn.576965","rule_count":4,"instance_count":1}}`)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	firewalls, err := v.GetFirewalls()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(firewalls)) {
+		assert.Equal(t, "1234abcd", firewalls[0].Id)
+		assert.Equal(t, "easy-vpn.576965", firewalls[0].Name)
+	}
+}
+
+func Test_Provider_Vultr_GetFirewalls_NoGroups(t *testing.T) {
+	server := getTestServer(http.StatusOK, `[]`)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	firewalls, err := v.GetFirewalls()
+	assert.Nil(t, err)
+	assert.Nil(t, firewalls)
+}
+
+func Test_Provider_Vultr_CreateFirewall(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, r.URL.Path+" "+r.PostForm.Encode())
+		if r.URL.Path == "/firewall/group_create" {
+			fmt.Fprint(w, `{"FIREWALLGROUPID":"1234abcd"}`)
+		}
+	}))
+	baseUrl = ts.URL
+	defer ts.Close()
+
+	v := Vultr{Config: testConfig}
+
+	id, err := v.CreateFirewall("easy-vpn.576965", "576965", []provider.FirewallRule{
+		{Protocol: "tcp", Port: "22", Sources: []string{"203.0.113.7/32"}},
+		{Protocol: "gre"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "1234abcd", id)
+	assert.Equal(t, []string{
+		"/firewall/group_create description=easy-vpn.576965",
+		"/firewall/rule_create FIREWALLGROUPID=1234abcd&direction=in&ip_type=v4&port=22&protocol=tcp&subnet=203.0.113.7&subnet_size=32",
+		"/firewall/rule_create FIREWALLGROUPID=1234abcd&direction=in&ip_type=v4&protocol=gre&subnet=0.0.0.0&subnet_size=0",
+		"/firewall/rule_create FIREWALLGROUPID=1234abcd&direction=in&ip_type=v6&protocol=gre&subnet=%3A%3A&subnet_size=0",
+		"/server/firewall_group_set FIREWALLGROUPID=1234abcd&SUBID=576965",
+	}, requests)
+}
+
+func Test_Provider_Vultr_CreateFirewall_Error(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/firewall/group_create":
+			fmt.Fprint(w, `{"FIREWALLGROUPID":"1234abcd"}`)
+		case "/firewall/rule_create":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `Invalid protocol`)
+		}
+	}))
+	baseUrl = ts.URL
+	defer ts.Close()
+
+	v := Vultr{Config: testConfig}
+
+	_, err := v.CreateFirewall("easy-vpn.576965", "576965", []provider.FirewallRule{{Protocol: "sctp"}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Invalid protocol", err.Error())
+	}
+	// the half-created group is deleted again
+	assert.Equal(t, []string{"/firewall/group_create", "/firewall/rule_create", "/firewall/group_delete"}, requests)
+}
+
+func Test_Provider_Vultr_DeleteFirewall(t *testing.T) {
+	server := getTestServer(http.StatusOK, ``)
+	defer server.Close()
+
+	v := Vultr{Config: testConfig}
+
+	assert.Nil(t, v.DeleteFirewall("1234abcd"))
+}
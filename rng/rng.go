@@ -1,29 +1,101 @@
 package rng
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
 )
 
-var chars = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz1234567890")
+// character classes for passwords, symbols leave out anything with a special meaning
+// to a shell or within chap-secrets, like quotes, backslashes, whitespace, "*" and "#"
+var classes = map[string]string{
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"digits":  "1234567890",
+	"symbols": "!%+,-.:=@^_~",
+}
+
+// Policy describes how passwords are generated
+type Policy struct {
+	Length  int      // number of characters
+	Classes []string // any of "upper", "lower", "digits" and "symbols", each of them is used at least once
+	Words   int      // if > 0 a passphrase of this many words is generated instead
+}
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+var DefaultPolicy = Policy{
+	Length:  12,
+	Classes: []string{"upper", "lower", "digits"},
 }
 
 func GenerateUsername() string {
-	firstChar := chars[rand.Intn(len(chars)-10)]
-	return string(firstChar) + getRandomCharacters(7)
+	letters := classes["upper"] + classes["lower"]
+	return pick(letters, 1) + pick(letters+classes["digits"], 7)
 }
 
 func GeneratePassword() string {
-	return getRandomCharacters(12)
+	password, _ := DefaultPolicy.Generate() // the default policy is valid
+	return password
+}
+
+// Generate returns a new password or passphrase according to the policy
+func (p Policy) Generate() (string, error) {
+	if p.Words > 0 {
+		words := make([]string, p.Words)
+		for i := range words {
+			words[i] = wordlist[intn(len(wordlist))]
+		}
+		return strings.Join(words, "-"), nil
+	}
+
+	if len(p.Classes) == 0 {
+		return "", fmt.Errorf("No character classes given for passwords")
+	}
+	if p.Length < len(p.Classes) {
+		return "", fmt.Errorf("Password length of %d is too short for %d character classes", p.Length, len(p.Classes))
+	}
+
+	// one character of each class, the rest from all of them, then shuffled
+	var all string
+	password := make([]byte, 0, p.Length)
+	for _, class := range p.Classes {
+		chars, ok := classes[class]
+		if !ok {
+			return "", fmt.Errorf("Unknown character class: %s", class)
+		}
+		if !strings.Contains(all, chars) {
+			all += chars
+		}
+		password = append(password, pick(chars, 1)...)
+	}
+	password = append(password, pick(all, p.Length-len(password))...)
+
+	for i := len(password) - 1; i > 0; i-- {
+		j := intn(i + 1)
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
 }
 
-func getRandomCharacters(num int) string {
-	out := make([]rune, num)
+func pick(chars string, num int) string {
+	out := make([]byte, num)
 	for idx := range out {
-		out[idx] = chars[rand.Intn(len(chars))]
+		out[idx] = chars[intn(len(chars))]
 	}
 	return string(out)
 }
+
+// intn returns a uniformly distributed random number in [0,n) from crypto/rand,
+// rejecting values above the largest multiple of n to avoid any modulo bias
+func intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	var buf [8]byte
+	for {
+		if _, err := rand.Read(buf[:]); err != nil {
+			panic(fmt.Sprintf("Could not read from crypto/rand: %v", err))
+		}
+		if value := binary.BigEndian.Uint64(buf[:]); value < limit {
+			return int(value % uint64(n))
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package rng
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_Rng_GenerateUsername(t *testing.T) {
-	username := GenerateUsername()
-	if assert.NotNil(t, username) {
-		assert.Equal(t, 8, len(username))
+	for i := 0; i < 100; i++ {
+		username := GenerateUsername()
+		if assert.Equal(t, 8, len(username)) {
+			assert.Contains(t, classes["upper"]+classes["lower"], username[:1])
+			assert.True(t, onlyOf(username, classes["upper"]+classes["lower"]+classes["digits"]), username)
+		}
 	}
 }
 
@@ -18,4 +22,98 @@ func Test_Rng_GeneratePassword(t *testing.T) {
 	if assert.NotNil(t, password) {
 		assert.Equal(t, 12, len(password))
 	}
+	assert.NotEqual(t, password, GeneratePassword())
+}
+
+func Test_Rng_Policy_Generate(t *testing.T) {
+	policy := Policy{Length: 6, Classes: []string{"upper", "digits", "symbols"}}
+	for i := 0; i < 200; i++ {
+		password, err := policy.Generate()
+		assert.Nil(t, err)
+		if assert.Equal(t, 6, len(password)) {
+			assert.True(t, onlyOf(password, classes["upper"]+classes["digits"]+classes["symbols"]), password)
+			// every class is guaranteed to be used
+			assert.True(t, strings.ContainsAny(password, classes["upper"]), password)
+			assert.True(t, strings.ContainsAny(password, classes["digits"]), password)
+			assert.True(t, strings.ContainsAny(password, classes["symbols"]), password)
+		}
+	}
+}
+
+func Test_Rng_Policy_Generate_Invalid(t *testing.T) {
+	_, err := Policy{Length: 12}.Generate()
+	assert.NotNil(t, err)
+
+	_, err = Policy{Length: 2, Classes: []string{"upper", "lower", "digits"}}.Generate()
+	assert.NotNil(t, err)
+
+	_, err = Policy{Length: 12, Classes: []string{"emoji"}}.Generate()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "Unknown character class: emoji", err.Error())
+	}
+}
+
+func Test_Rng_Policy_Generate_Passphrase(t *testing.T) {
+	passphrase, err := Policy{Words: 5}.Generate()
+	assert.Nil(t, err)
+
+	words := strings.Split(passphrase, "-")
+	if assert.Equal(t, 5, len(words)) {
+		for _, word := range words {
+			assert.Contains(t, wordlist, word)
+		}
+	}
+}
+
+func Test_Rng_Wordlist(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, word := range wordlist {
+		assert.False(t, seen[word], word)
+		assert.False(t, strings.Contains(word, "-"), word)
+		seen[word] = true
+	}
+	assert.True(t, len(wordlist) >= 256)
+}
+
+func Test_Rng_Intn_Distribution(t *testing.T) {
+	// chi-squared test over 10 buckets, 9 degrees of freedom,
+	// 27.88 is the critical value for p = 0.001 so this fails only once in a thousand runs if intn is uniform
+	const buckets, samples = 10, 100000
+	counts := make([]int, buckets)
+	for i := 0; i < samples; i++ {
+		n := intn(buckets)
+		if n < 0 || n >= buckets {
+			t.Fatalf("%d out of range", n)
+		}
+		counts[n]++
+	}
+
+	expected := float64(samples) / buckets
+	var chi2 float64
+	for _, count := range counts {
+		chi2 += (float64(count) - expected) * (float64(count) - expected) / expected
+	}
+	assert.True(t, chi2 < 27.88, "chi-squared of %f for %v", chi2, counts)
+}
+
+func Test_Rng_Pick_Distribution(t *testing.T) {
+	// every character of the set shows up with about the same frequency
+	chars := classes["digits"] + classes["symbols"]
+	counts := make(map[rune]int)
+	for _, c := range pick(chars, 22000) {
+		counts[c]++
+	}
+	assert.Equal(t, len(chars), len(counts))
+	for c, count := range counts {
+		assert.True(t, count > 700 && count < 1300, "%c appeared %d times", c, count)
+	}
+}
+
+func onlyOf(s, chars string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(chars, c) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/codegangsta/cli"
+)
+
+// interruptible returns a context that is canceled on the first Ctrl-C or SIGTERM,
+// after which the signal handler is removed again so a second Ctrl-C terminates easy-vpn right away
+func interruptible(c *cli.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintln(messages(c), "\nInterrupted, aborting... (press Ctrl-C again to exit immediately)")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
+
+// rollback takes care of a virtual machine that was left behind half-provisioned by a failed "up".
+// With --rollback-on-failure it is destroyed right away, if "up" was interrupted the user is asked for it.
+func rollback(c *cli.Context, e *easyvpn.Engine, machine provider.VM, interrupted bool) {
+	out := messages(c)
+
+	if len(machine.Id) == 0 {
+		fmt.Fprintf(out, "Virtual machine [%s] might have been left behind, check with \"easy-vpn show\"\n", machine.Name)
+		return
+	}
+	if !c.Bool("rollback-on-failure") {
+		if !interrupted {
+			fmt.Fprintf(out, "Virtual machine [%s] was left behind half-provisioned, remove it with \"easy-vpn down\"\n", machine.Name)
+			return
+		}
+		fmt.Fprintf(out, "Virtual machine [%s] was left behind half-provisioned, do you want to destroy it?\n", machine.Name)
+		if !confirm(c) {
+			return
+		}
+	}
+
+	// use a fresh context, the one of "up" might already be canceled
+	fmt.Fprintf(out, "Destroy virtual machine [%s]\n", machine.Name)
+	if err := e.Destroy(context.Background(), machine); err != nil {
+		fmt.Fprintf(out, "Could not destroy virtual machine [%s]: %v\n", machine.Name, err)
+	}
+}
@@ -35,9 +35,13 @@ function destroyVM {
 }
 
 STARTTIME=`date +%s`
+DEADLINE=$(( STARTTIME + UPTIME ))
+
+# remember deadline, so "easy-vpn status" can report the remaining lifetime
+echo "${DEADLINE}" > /root/self-destruct.deadline
+
 while true; do
 	CURRENTTIME=`date +%s`
-	DEADLINE=$(( STARTTIME + UPTIME ))
 	if [[ $CURRENTTIME -gt $DEADLINE ]]; then
 		destroyVM
 	fi
@@ -0,0 +1,199 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// Passphrase asks for the passphrase of an encrypted private key, it is a variable so tests can answer instead
+var Passphrase = func(filename string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("Private key %s is encrypted, but there is no terminal to ask for its passphrase.\n"+
+			"Add it to ssh-agent instead", filename)
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", filename)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// decrypted private keys, so the passphrase is only asked for once, even by the concurrent provisionings of a fleet
+var (
+	decryptLock sync.Mutex
+	decrypted   = make(map[string]gossh.Signer)
+)
+
+// signers are the keys to log into a virtual machine with: its ephemeral key if it has one, those of ssh-agent
+// if SSH_AUTH_SOCK is set, and the configured private key. An encrypted private key is only decrypted once
+// a server is willing to accept it. The returned io.Closer closes the connection to ssh-agent, once logged in.
+func signers(cfg *config.Config, vmName string) ([]gossh.Signer, io.Closer, error) {
+	var result []gossh.Signer
+	var closer io.Closer = ioutil.NopCloser(nil)
+
+	ephemeral := cfg.SshEphemeralKey
+	if len(vmName) > 0 {
+		key, err := ephemeralKey(cfg, vmName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != nil {
+			result = append(result, key)
+			ephemeral = true
+		}
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); len(socket) > 0 {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			keys, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				conn.Close()
+				return nil, nil, fmt.Errorf("Could not list keys of ssh-agent\n%v", err)
+			}
+			result, closer = append(result, keys...), conn
+		}
+	}
+
+	if len(cfg.PrivateKeyFile) > 0 {
+		signer, err := privateKey(cfg)
+		if err != nil && !ephemeral {
+			closer.Close()
+			return nil, nil, err
+		}
+		// with an ephemeral key the configured one is not needed, it might well not exist
+		if err == nil && !contains(result, signer.PublicKey()) {
+			result = append(result, signer)
+		}
+	}
+
+	if len(result) == 0 {
+		closer.Close()
+		return nil, nil, fmt.Errorf("No SSH key to log in with, configure ssh_private_key or add a key to ssh-agent")
+	}
+	return result, closer, nil
+}
+
+// publicKey is the key to publish to the provider: the configured public key file, or the one of the configured private key,
+// or the first key of ssh-agent
+func publicKey(cfg *config.Config) (string, error) {
+	if len(cfg.PublicKeyFile) > 0 {
+		data, err := loadKeyFile(cfg.PublicKeyFile)
+		return string(data), err
+	}
+
+	if len(cfg.PrivateKeyFile) > 0 {
+		signer, err := privateKey(cfg)
+		if err != nil {
+			return "", err
+		}
+		return string(gossh.MarshalAuthorizedKey(signer.PublicKey())), nil
+	}
+
+	keys, closer, err := signers(cfg, "")
+	if err != nil {
+		return "", err
+	}
+	defer closer.Close()
+	return string(gossh.MarshalAuthorizedKey(keys[0].PublicKey())), nil
+}
+
+// privateKey parses the configured private key, any of RSA, ECDSA or Ed25519 in PEM or OpenSSH format
+func privateKey(cfg *config.Config) (gossh.Signer, error) {
+	filename, err := sanitizeFilename(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := loadKeyFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	if err == nil {
+		return signer, nil
+	}
+	missing, encrypted := err.(*gossh.PassphraseMissingError)
+	if !encrypted {
+		return nil, fmt.Errorf("Could not parse private key\n%v", err)
+	}
+
+	// only keys in OpenSSH format tell their public key without the passphrase, otherwise it has to come from a .pub file
+	public := missing.PublicKey
+	if public == nil {
+		if pub, err := ioutil.ReadFile(filename + ".pub"); err == nil {
+			public, _, _, _, _ = gossh.ParseAuthorizedKey(pub)
+		}
+	}
+	if public == nil {
+		return decrypt(filename, data)
+	}
+	return &encryptedSigner{filename: filename, data: data, public: public}, nil
+}
+
+func decrypt(filename string, data []byte) (gossh.Signer, error) {
+	decryptLock.Lock()
+	defer decryptLock.Unlock()
+
+	if signer, ok := decrypted[filename]; ok {
+		return signer, nil
+	}
+	passphrase, err := Passphrase(filename)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := gossh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt private key: %s\n%v", filename, err)
+	}
+	decrypted[filename] = signer
+	return signer, nil
+}
+
+// encryptedSigner asks for the passphrase of its private key when it has to sign for the first time
+type encryptedSigner struct {
+	filename string
+	data     []byte
+	public   gossh.PublicKey
+}
+
+func (s *encryptedSigner) PublicKey() gossh.PublicKey {
+	return s.public
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*gossh.Signature, error) {
+	signer, err := decrypt(s.filename, s.data)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm allows RSA keys to sign with SHA-2, as servers refuse SHA-1 signatures nowadays
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*gossh.Signature, error) {
+	signer, err := decrypt(s.filename, s.data)
+	if err != nil {
+		return nil, err
+	}
+	if as, ok := signer.(gossh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+func contains(signers []gossh.Signer, key gossh.PublicKey) bool {
+	for _, signer := range signers {
+		if string(signer.PublicKey().Marshal()) == string(key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ed25519Key writes a new private key in OpenSSH format, encrypted if a passphrase is given
+func ed25519Key(t *testing.T, dir string, passphrase string) (string, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if len(passphrase) > 0 {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = gossh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "id_ed25519_"+passphrase)
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename, key
+}
+
+func authorizedKey(t *testing.T, key ed25519.PrivateKey) string {
+	public, err := gossh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(gossh.MarshalAuthorizedKey(public))
+}
+
+func Test_SSH_EncryptedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(passphrase func(string) ([]byte, error)) { Passphrase = passphrase }(Passphrase)
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", "")
+
+	asked := 0
+	Passphrase = func(filename string) ([]byte, error) {
+		asked++
+		return []byte("s3cr3t"), nil
+	}
+
+	filename, key := ed25519Key(t, dir, "s3cr3t")
+	cfg := &config.Config{PrivateKeyFile: filename}
+
+	// the public key is known without the passphrase
+	public, err := publicKey(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, authorizedKey(t, key), public)
+	keys, closer, err := signers(cfg, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+	closer.Close()
+	assert.Equal(t, 0, asked)
+
+	// asked for once it has to sign
+	for i := 0; i < 2; i++ {
+		signature, err := keys[0].Sign(rand.Reader, []byte("data"))
+		assert.Nil(t, err)
+		assert.Nil(t, keys[0].PublicKey().Verify([]byte("data"), signature))
+	}
+	assert.Equal(t, 1, asked)
+
+	filename, _ = ed25519Key(t, dir, "other")
+	keys, _, err = signers(&config.Config{PrivateKeyFile: filename}, "")
+	assert.Nil(t, err)
+	_, err = keys[0].Sign(rand.Reader, []byte("data"))
+	assert.NotNil(t, err)
+}
+
+func Test_SSH_Agent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+
+	filename, key := ed25519Key(t, dir, "")
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	assert.Nil(t, keyring.Add(agent.AddedKey{PrivateKey: other}))
+	assert.Nil(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	os.Setenv("SSH_AUTH_SOCK", socket)
+
+	// the configured key is already held by ssh-agent
+	keys, closer, err := signers(&config.Config{PrivateKeyFile: filename}, "")
+	if assert.Nil(t, err) {
+		closer.Close()
+		assert.Equal(t, 2, len(keys))
+	}
+	public, err := publicKey(&config.Config{PrivateKeyFile: filename})
+	assert.Nil(t, err)
+	assert.Equal(t, authorizedKey(t, key), public)
+
+	// without any configured key, the first one of ssh-agent is published
+	public, err = publicKey(&config.Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, authorizedKey(t, other), public)
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	_, _, err = signers(&config.Config{}, "")
+	assert.NotNil(t, err)
+}
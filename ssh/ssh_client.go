@@ -0,0 +1,128 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/JamesClonk/easy-vpn/provider"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Client is an SSH connection to a virtual machine. It is dialed on first use and shared by all commands run through it,
+// each in a session of its own, and redialed if it broke in between, like when the virtual machine rebooted.
+type Client struct {
+	provider provider.API
+	machine  provider.VM
+
+	lock sync.Mutex
+	conn *gossh.Client
+}
+
+func NewClient(p provider.API, machine provider.VM) *Client {
+	return &Client{provider: p, machine: machine}
+}
+
+// Run executes a command, as root through sudo if the SSH user is not root, and returns its output
+func (c *Client) Run(ctx context.Context, cmd string) (string, error) {
+	conn, session, err := c.session(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdOut bytes.Buffer
+	var stdErr bytes.Buffer
+	session.Stdout = &stdOut
+	session.Stderr = &stdErr
+	if err := withContext(ctx, session, func() error { return session.Run(sudo(conn.User(), cmd)) }); err != nil {
+		c.check(conn, err)
+		return "", fmt.Errorf("%s\n%v", stdErr.String(), err)
+	}
+
+	return stdOut.String(), nil
+}
+
+// UploadSelfDestruct uploads the self-destruct script to /root/self-destruct.sh
+func (c *Client) UploadSelfDestruct(ctx context.Context, filename string) error {
+	filename, err := sanitizeFilename(filename)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Could not read in file: %s\n%v", filename, err)
+	}
+
+	conn, session, err := c.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// piped through sudo rather than scp, which could only write where the SSH user may
+	var stdErr bytes.Buffer
+	session.Stdin = bytes.NewReader(data)
+	session.Stderr = &stdErr
+	cmd := sudo(conn.User(), `cat > /root/self-destruct.sh && chmod 750 /root/self-destruct.sh`)
+	if err := withContext(ctx, session, func() error { return session.Run(cmd) }); err != nil {
+		c.check(conn, err)
+		return fmt.Errorf("Could not transfer file\n%s\n%v", stdErr.String(), err)
+	}
+	return nil
+}
+
+// Close closes the connection, the next command dials a new one
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// session opens a new session, dialing the connection first if there is none or it broke
+func (c *Client) session(ctx context.Context) (*gossh.Client, *gossh.Session, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != nil {
+		if session, err := c.conn.NewSession(); err == nil {
+			return c.conn, session, nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := connect(ctx, c.provider, c.machine)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("Could not create SSH session\n%v", err)
+	}
+	c.conn = conn
+	return conn, session, nil
+}
+
+// check drops the connection if a command failed without exit status, as the connection itself is then probably broken
+func (c *Client) check(conn *gossh.Client, err error) {
+	if _, exited := err.(*gossh.ExitError); exited {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == conn {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
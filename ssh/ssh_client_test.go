@@ -0,0 +1,155 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// server is an SSH server answering every command with the command itself, counting the connections made to it
+type server struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func newServer(t *testing.T) *server {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go s.serve(conn, serverConfig)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(conn net.Conn, config *gossh.ServerConfig) {
+	_, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				var payload struct{ Command string }
+				gossh.Unmarshal(req.Payload, &payload)
+				channel.Write([]byte(payload.Command))
+				channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+func (s *server) connections() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+// drop breaks all connections, like a reboot of the virtual machine
+func (s *server) drop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func Test_SSH_Client(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer(t)
+	defer s.listener.Close()
+	port := s.listener.Addr().(*net.TCPAddr).Port
+	p := test.MockProvider{Config: &config.Config{PrivateKeyFile: keyFile, StateDir: dir, SshPort: port}}
+
+	client := NewClient(p, provider.VM{Name: "easy-vpn", IP: "127.0.0.1"})
+	for i := 0; i < 3; i++ {
+		out, err := client.Run(context.Background(), "uptime")
+		assert.Nil(t, err)
+		assert.Equal(t, "uptime", out)
+	}
+	assert.Equal(t, 1, s.connections())
+
+	s.drop()
+	out, err := client.Run(context.Background(), "uptime")
+	if err != nil { // the broken connection might only be noticed by the command
+		out, err = client.Run(context.Background(), "uptime")
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, "uptime", out)
+	assert.Equal(t, 2, s.connections())
+
+	assert.Nil(t, client.Close())
+	assert.Nil(t, client.Close())
+}
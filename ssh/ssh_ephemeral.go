@@ -0,0 +1,128 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// EPHEMERAL_SUFFIX is appended to the name of a virtual machine to name its ephemeral ssh-key at the provider
+const EPHEMERAL_SUFFIX = ".ephemeral"
+
+// ephemeralKeyDir in the state directory holds the private keys of the ephemeral ssh-keys, by name of their virtual machine
+const ephemeralKeyDir = "keys"
+
+// EphemeralKeyId installs the ephemeral ssh-key of a virtual machine at the provider and returns its id.
+// The Ed25519 keypair is generated first, unless there is one left from an interrupted or self-destructed machine of the same name.
+func EphemeralKeyId(p provider.API, vmName string) (string, error) {
+	cfg := p.GetConfig()
+	signer, err := ephemeralKey(cfg, vmName)
+	if err != nil {
+		return "", err
+	}
+	if signer == nil {
+		if signer, err = generateEphemeralKey(cfg, vmName); err != nil {
+			return "", err
+		}
+	}
+	return installKey(p, vmName+EPHEMERAL_SUFFIX, string(gossh.MarshalAuthorizedKey(signer.PublicKey())))
+}
+
+// DeleteEphemeralKey deletes the ephemeral ssh-key of a virtual machine from the provider, and its private key file
+func DeleteEphemeralKey(p provider.API, vmName string) error {
+	keys, err := p.GetInstalledSshKeys()
+	if err != nil {
+		return fmt.Errorf("Could not retrieve list of installed SSH-Keys\n%v", err)
+	}
+	for _, key := range keys {
+		if key.Name == vmName+EPHEMERAL_SUFFIX {
+			if err := p.DeleteSshKey(key.Id); err != nil {
+				return fmt.Errorf("Could not delete SSH-Key\n%v", err)
+			}
+		}
+	}
+
+	filename, err := ephemeralKeyFile(p.GetConfig(), vmName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove file: %s\n%v", filename, err)
+	}
+	return nil
+}
+
+// IsEphemeralKey tells whether an ssh-key at the provider is the ephemeral one of a virtual machine, and of which
+func IsEphemeralKey(keyName string) (vmName string, ok bool) {
+	if !strings.HasSuffix(keyName, EPHEMERAL_SUFFIX) {
+		return "", false
+	}
+	return strings.TrimSuffix(keyName, EPHEMERAL_SUFFIX), true
+}
+
+// ephemeralKey loads the ephemeral private key of a virtual machine, or returns nil if it has none
+func ephemeralKey(cfg *config.Config, vmName string) (gossh.Signer, error) {
+	filename, err := ephemeralKeyFile(cfg, vmName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read ssh key file: %s\n%v", filename, err)
+	}
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse private key: %s\n%v", filename, err)
+	}
+	return signer, nil
+}
+
+func generateEphemeralKey(cfg *config.Config, vmName string) (gossh.Signer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not generate SSH-Key\n%v", err)
+	}
+	block, err := gossh.MarshalPrivateKey(private, vmName+EPHEMERAL_SUFFIX)
+	if err != nil {
+		return nil, fmt.Errorf("Could not generate SSH-Key\n%v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("Could not generate SSH-Key\n%v", err)
+	}
+
+	filename, err := ephemeralKeyFile(cfg, vmName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("Could not create state directory: %s\n%v", filepath.Dir(filename), err)
+	}
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("Could not write file: %s\n%v", filename, err)
+	}
+	return signer, nil
+}
+
+func ephemeralKeyFile(cfg *config.Config, vmName string) (string, error) {
+	if len(vmName) == 0 || strings.ContainsAny(vmName, `/\`) || strings.HasPrefix(vmName, ".") {
+		return "", fmt.Errorf("Invalid virtual machine name for an ephemeral SSH-Key: %q", vmName)
+	}
+	dir, err := config.ExpandHome(cfg.GetStateDir())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ephemeralKeyDir, vmName), nil
+}
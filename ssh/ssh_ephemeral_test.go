@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/provider"
+	"github.com/JamesClonk/easy-vpn/test"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// keyProvider records the ssh-keys deleted
+type keyProvider struct {
+	test.MockProvider
+	deleted *[]string
+}
+
+func (p keyProvider) DeleteSshKey(id string) error {
+	*p.deleted = append(*p.deleted, id)
+	return nil
+}
+
+func Test_SSH_EphemeralKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", "")
+
+	var deleted []string
+	p := keyProvider{
+		MockProvider: test.MockProvider{
+			Config: &config.Config{StateDir: dir},
+			Keys: []provider.SshKey{
+				{Id: "k1", Name: "easy-vpn"},
+				{Id: "k2", Name: "easy-vpn-ams3.ephemeral"},
+			},
+		},
+		deleted: &deleted,
+	}
+
+	// installed under a name of its own
+	keyId, err := EphemeralKeyId(p, "easy-vpn-fra1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(keyId, "easy-vpn-fra1.ephemeral:ssh-ed25519 "))
+	filename := filepath.Join(dir, "keys", "easy-vpn-fra1")
+	info, err := os.Stat(filename)
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// offered when logging into its virtual machine only
+	keys, _, err := signers(p.GetConfig(), "easy-vpn-fra1")
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(keys)) {
+		assert.Equal(t, keyId[len("easy-vpn-fra1.ephemeral:"):], string(gossh.MarshalAuthorizedKey(keys[0].PublicKey())))
+	}
+	_, _, err = signers(p.GetConfig(), "easy-vpn-ams3")
+	assert.NotNil(t, err)
+
+	// a configured private key that does not exist is of no concern then
+	missing := &config.Config{StateDir: dir, PrivateKeyFile: filepath.Join(dir, "vps_rsa")}
+	keys, _, err = signers(missing, "easy-vpn-fra1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(keys))
+	_, _, err = signers(missing, "easy-vpn-ams3")
+	assert.NotNil(t, err)
+	missing.SshEphemeralKey = true
+	_, _, err = signers(missing, "easy-vpn-ams3")
+	assert.Contains(t, err.Error(), "No SSH key")
+
+	// reused by the next machine of the same name
+	again, err := EphemeralKeyId(p, "easy-vpn-fra1")
+	assert.Nil(t, err)
+	assert.Equal(t, keyId, again)
+
+	assert.Nil(t, DeleteEphemeralKey(p, "easy-vpn-ams3"))
+	assert.Equal(t, []string{"k2"}, deleted)
+	assert.Nil(t, DeleteEphemeralKey(p, "easy-vpn-fra1"))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = EphemeralKeyId(p, "../easy-vpn")
+	assert.NotNil(t, err)
+}
+
+func Test_SSH_IsEphemeralKey(t *testing.T) {
+	vmName, ok := IsEphemeralKey("easy-vpn-ams3.ephemeral")
+	assert.True(t, ok)
+	assert.Equal(t, "easy-vpn-ams3", vmName)
+	_, ok = IsEphemeralKey("easy-vpn")
+	assert.False(t, ok)
+}
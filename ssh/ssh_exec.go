@@ -1,98 +1,126 @@
 package ssh
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/JamesClonk/easy-vpn/config"
 	"github.com/JamesClonk/easy-vpn/provider"
 	gossh "golang.org/x/crypto/ssh"
 )
 
-func Call(p provider.API, ip string, cmd string) {
-	fmt.Println(Exec(p, ip, cmd))
+// Run executes a command on a virtual machine through a connection of its own
+func Run(ctx context.Context, p provider.API, machine provider.VM, cmd string) (string, error) {
+	client := NewClient(p, machine)
+	defer client.Close()
+	return client.Run(ctx, cmd)
 }
 
-func Exec(p provider.API, ip string, cmd string) string {
-	out, err := Run(p, ip, cmd)
-	if err != nil {
-		log.Println("Could not run command through SSH: " + cmd)
-		log.Fatal(err)
+// sudo wraps a command to run as root, unless the SSH user already is root
+func sudo(user string, cmd string) string {
+	if user == "root" {
+		return cmd
 	}
-	return out
+	return "sudo -n sh -c " + quote(cmd)
 }
 
-func Run(p provider.API, ip string, cmd string) (string, error) {
-	session := sshConnect(p, ip)
-	defer session.Close()
-
-	var stdOut bytes.Buffer
-	var stdErr bytes.Buffer
-	session.Stdout = &stdOut
-	session.Stderr = &stdErr
-	if err := session.Run(cmd); err != nil {
-		return "", errors.New(fmt.Sprintf("%s\n%v", stdErr.String(), err))
-	}
-
-	return stdOut.String(), nil
+// quote a string for the shell, as a single argument
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
 }
 
-func WriteSelfDestruct(p provider.API, ip string, filename string) {
-	session := sshConnect(p, ip)
-	defer session.Close()
-
-	data, err := ioutil.ReadFile(sanitizeFilename(filename))
+// connect logs in with the configured SSH user and port. A virtual machine that is not yet provisioned that far,
+// or was provisioned before either was configurable, only accepts root on port 22, so that is tried as well.
+func connect(ctx context.Context, p provider.API, machine provider.VM) (*gossh.Client, error) {
+	cfg := p.GetConfig()
+	ip := machine.IP
+	keys, closer, err := signers(cfg, machine.Name)
 	if err != nil {
-		log.Println("Could not read in file: " + filename)
-		log.Fatal(err)
+		return nil, err
 	}
+	defer closer.Close() // the connection to ssh-agent is only needed to log in
 
-	go func() {
-		writer, err := session.StdinPipe()
-		if err != nil {
-			log.Fatal(err)
+	user, port := cfg.GetSshUser(), cfg.GetSshPort()
+	client, err := dial(ctx, cfg, ip, user, port, keys)
+	if _, mismatch := err.(*HostKeyError); mismatch {
+		return nil, err
+	}
+	if err != nil && ctx.Err() == nil && (user != config.DEFAULT_SSH_USER || port != config.DEFAULT_SSH_PORT) {
+		if c, rootErr := dial(ctx, cfg, ip, config.DEFAULT_SSH_USER, config.DEFAULT_SSH_PORT, keys); rootErr == nil {
+			client, err = c, nil
 		}
-		defer writer.Close()
-
-		fmt.Fprintln(writer, "C0750", len(data), "self-destruct.sh")
-		fmt.Fprint(writer, string(data))
-		fmt.Fprint(writer, "\x00")
-	}()
-
-	if err := session.Run("scp -qrt ./"); err != nil {
-		log.Println("Could not transfer file through scp")
-		log.Fatal(err)
 	}
+	return client, err
 }
 
-func sshConnect(p provider.API, ip string) *gossh.Session {
-	key := readKeyFile(p.GetConfig().PrivateKeyFile)
-
-	signer, err := gossh.ParsePrivateKey(key)
+func dial(ctx context.Context, cfg *config.Config, ip string, user string, port int, keys []gossh.Signer) (*gossh.Client, error) {
+	// remember a host key mismatch, to report it as such rather than as any connection failure
+	var mismatch error
+	verify := hostKeyCallback(cfg)
+	algorithms, err := hostKeyAlgorithms(cfg, ip)
 	if err != nil {
-		log.Println("Could not parse private key")
-		log.Fatal(err)
+		return nil, err
 	}
-
-	config := &gossh.ClientConfig{
-		User: "root",
+	clientConfig := &gossh.ClientConfig{
+		User: user,
 		Auth: []gossh.AuthMethod{
-			gossh.PublicKeys(signer),
+			gossh.PublicKeys(keys...),
 		},
+		HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			err := verify(hostname, remote, key)
+			if _, ok := err.(*HostKeyError); ok {
+				mismatch = err
+			}
+			return err
+		},
+		HostKeyAlgorithms: algorithms,
 	}
 
-	client, err := gossh.Dial("tcp", ip+":22", config)
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", addr)
 	if err != nil {
-		log.Println("Could not connect to: " + ip)
-		log.Fatal(err)
+		return nil, fmt.Errorf("Could not connect to: %s\n%v", addr, err)
 	}
 
-	session, err := client.NewSession()
-	if err != nil {
-		log.Println("Could not create SSH session")
-		log.Fatal(err)
+	var client *gossh.Client
+	if err := withContext(ctx, conn, func() error {
+		c, chans, reqs, err := gossh.NewClientConn(conn, addr, clientConfig)
+		if err != nil {
+			return err
+		}
+		client = gossh.NewClient(c, chans, reqs)
+		return nil
+	}); err != nil {
+		conn.Close()
+		if mismatch != nil {
+			return nil, mismatch
+		}
+		return nil, fmt.Errorf("Could not connect to: %s as %s\n%v", addr, user, err)
+	}
+	return client, nil
+}
+
+// withContext runs fn, closing the connection if ctx is canceled in the meantime to abort it
+func withContext(ctx context.Context, conn io.Closer, fn func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	return session
+	return err
 }
@@ -0,0 +1,13 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SSH_Sudo(t *testing.T) {
+	assert.Equal(t, `echo "hi" > /root/x`, sudo("root", `echo "hi" > /root/x`))
+	assert.Equal(t, `sudo -n sh -c 'echo "hi" > /root/x'`, sudo("easyvpn", `echo "hi" > /root/x`))
+	assert.Equal(t, `sudo -n sh -c 'docker exec pptpd sh -c '\''ls /'\'''`, sudo("easyvpn", `docker exec pptpd sh -c 'ls /'`))
+}
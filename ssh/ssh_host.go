@@ -0,0 +1,194 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// knownHostsFile in the state directory pins the host key of each virtual machine, by IP address
+const knownHostsFile = "known_hosts"
+
+// known_hosts is read and rewritten by concurrent provisionings of a fleet
+var knownHostsLock sync.Mutex
+
+// HostKeyError is returned if a server presents another host key than the one pinned for its IP address
+type HostKeyError struct {
+	IP   string
+	File string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("Host key of [%s] does not match the one pinned in %s, somebody might be intercepting the connection.\n"+
+		"If the IP address belongs to a new virtual machine, remove its line from that file", e.IP, e.File)
+}
+
+// NewHostKey generates a host key for a virtual machine that is about to be created, and the cloud-init
+// user data installing it, so that already the very first SSH connection can be verified
+func NewHostKey() (userData string, key gossh.PublicKey, err error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not generate host key\n%v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not generate host key\n%v", err)
+	}
+	key, err = gossh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not generate host key\n%v", err)
+	}
+
+	pemKey := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	userData = "#cloud-config\nssh_deletekeys: true\nssh_keys:\n" +
+		"  ecdsa_private: |\n    " + strings.Replace(pemKey, "\n", "\n    ", -1) + "\n" +
+		"  ecdsa_public: " + strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key))) + "\n"
+	return userData, key, nil
+}
+
+// Trust pins the host key of an IP address, replacing whatever was pinned for it before
+func Trust(cfg *config.Config, ip string, key gossh.PublicKey) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	return updateKnownHosts(cfg, ip, key)
+}
+
+// Forget removes the pinned host key of an IP address, once its virtual machine is destroyed,
+// as the provider will hand out the IP address again
+func Forget(cfg *config.Config, ip string) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	return updateKnownHosts(cfg, ip, nil)
+}
+
+// hostKeyCallback verifies host keys against known_hosts, pinning the key of any IP address seen for the first time
+func hostKeyCallback(cfg *config.Config) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		ip, _, err := net.SplitHostPort(hostname)
+		if err != nil {
+			ip = hostname
+		}
+
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+
+		filename, hosts, err := readKnownHosts(cfg)
+		if err != nil {
+			return err
+		}
+		pinned, ok := hosts[ip]
+		if !ok {
+			return updateKnownHosts(cfg, ip, key)
+		}
+		if !bytes.Equal(pinned.Marshal(), key.Marshal()) {
+			return &HostKeyError{IP: ip, File: filename}
+		}
+		return nil
+	}
+}
+
+// hostKeyAlgorithms are the algorithms to ask a server for, so it presents the same type of host key as the one
+// pinned for its IP address rather than another one it also has, which could not be verified. Empty if none is pinned yet.
+func hostKeyAlgorithms(cfg *config.Config, ip string) ([]string, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	_, hosts, err := readKnownHosts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pinned, ok := hosts[ip]
+	if !ok {
+		return nil, nil
+	}
+	if pinned.Type() == gossh.KeyAlgoRSA {
+		return []string{gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSA}, nil
+	}
+	return []string{pinned.Type()}, nil
+}
+
+func knownHostsPath(cfg *config.Config) (string, error) {
+	dir, err := config.ExpandHome(cfg.GetStateDir())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, knownHostsFile), nil
+}
+
+// readKnownHosts parses lines of "<ip> <key type> <base64 key>", like an OpenSSH known_hosts file
+func readKnownHosts(cfg *config.Config) (string, map[string]gossh.PublicKey, error) {
+	filename, err := knownHostsPath(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	hosts := make(map[string]gossh.PublicKey)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return filename, hosts, nil
+	}
+	if err != nil {
+		return filename, nil, fmt.Errorf("Could not read file: %s\n%v", filename, err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(fields[1] + " " + fields[2]))
+		if err != nil {
+			return filename, nil, fmt.Errorf("Could not parse file: %s\n%v", filename, err)
+		}
+		hosts[fields[0]] = key
+	}
+	return filename, hosts, nil
+}
+
+// updateKnownHosts pins key for ip, or removes ip if key is nil, the caller must hold knownHostsLock
+func updateKnownHosts(cfg *config.Config, ip string, key gossh.PublicKey) error {
+	filename, hosts, err := readKnownHosts(cfg)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		if _, ok := hosts[ip]; !ok {
+			return nil
+		}
+		delete(hosts, ip)
+	} else {
+		hosts[ip] = key
+	}
+
+	ips := make([]string, 0, len(hosts))
+	for ip := range hosts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	var buf bytes.Buffer
+	for _, ip := range ips {
+		fmt.Fprintf(&buf, "%s %s", ip, gossh.MarshalAuthorizedKey(hosts[ip]))
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Could not create state directory: %s\n%v", filepath.Dir(filename), err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("Could not write file: %s\n%v", filename, err)
+	}
+	return nil
+}
@@ -1,22 +1,28 @@
 package ssh
 
 import (
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os/user"
 	"strings"
 
 	"github.com/JamesClonk/easy-vpn/provider"
 )
 
-func GetEasyVpnKeyId(p provider.API, keyName string) (keyId string) {
-	key := string(readKeyFile(p.GetConfig().PublicKeyFile))
+func EasyVpnKeyId(p provider.API, keyName string) (keyId string, err error) {
+	key, err := publicKey(p.GetConfig())
+	if err != nil {
+		return "", err
+	}
+	return installKey(p, keyName, key)
+}
 
+// installKey installs a public key under the given name at the provider, or updates the one already installed under it
+func installKey(p provider.API, keyName string, key string) (keyId string, err error) {
 	// first lets get all currently installed ssh-keys
 	keys, err := p.GetInstalledSshKeys()
 	if err != nil {
-		log.Println("Could not retrieve list of installed SSH-Keys")
-		log.Fatal(err)
+		return "", fmt.Errorf("Could not retrieve list of installed SSH-Keys\n%v", err)
 	}
 
 	// then check to see if easy-vpn ssh-key is already installed
@@ -33,40 +39,40 @@ func GetEasyVpnKeyId(p provider.API, keyName string) (keyId string) {
 	if keyInstalled {
 		keyId, err = p.UpdateSshKey(keyId, keyName, key)
 		if err != nil {
-			log.Println("Could not update SSH-Key")
-			log.Fatal(err)
+			return "", fmt.Errorf("Could not update SSH-Key\n%v", err)
 		}
 	} else { // otherwise, install as a new ssh-key
 		keyId, err = p.InstallNewSshKey(keyName, key)
 		if err != nil {
-			log.Println("Could not install SSH-Key")
-			log.Fatal(err)
+			return "", fmt.Errorf("Could not install SSH-Key\n%v", err)
 		}
 	}
 
-	return keyId
+	return keyId, nil
 }
 
-func readKeyFile(filename string) []byte {
-	data, err := ioutil.ReadFile(sanitizeFilename(filename))
+func loadKeyFile(filename string) ([]byte, error) {
+	filename, err := sanitizeFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Println("Could not read ssh key file: " + filename)
-		log.Fatal(err)
+		return nil, fmt.Errorf("Could not read ssh key file: %s\n%v", filename, err)
 	}
-	return data
+	return data, nil
 }
 
-func sanitizeFilename(filename string) string {
+func sanitizeFilename(filename string) (string, error) {
 	// replace beginning tilde (~) character with path to users home directory
 	if strings.HasPrefix(filename, `~`) {
 		usr, err := user.Current()
 		if err != nil {
-			log.Println("Could not get information about current user")
-			log.Fatal(err)
+			return "", fmt.Errorf("Could not get information about current user\n%v", err)
 		}
 		home := usr.HomeDir
 		filename = strings.Replace(filename, `~`, home, 1)
 	}
 
-	return filename
+	return filename, nil
 }
@@ -21,7 +21,7 @@ func init() {
 	}
 }
 
-func Test_SSH_GetEasyVpnKeyId(t *testing.T) {
+func Test_SSH_EasyVpnKeyId(t *testing.T) {
 	mockedProvider1 := test.MockProvider{
 		Config: cfg,
 		Keys: []provider.SshKey{
@@ -36,37 +36,42 @@ func Test_SSH_GetEasyVpnKeyId(t *testing.T) {
 		},
 	}
 
-	keyId1 := GetEasyVpnKeyId(mockedProvider1, "easy-vpn")
+	keyId1, err := EasyVpnKeyId(mockedProvider1, "easy-vpn")
+	assert.Nil(t, err)
 	if assert.NotNil(t, keyId1) {
 		assert.Equal(t, "mockId:easy-vpn:this would be a public key!\n;)\n", keyId1)
 	}
 
 	mockedProvider2 := test.MockProvider{Config: cfg}
-	keyId2 := GetEasyVpnKeyId(mockedProvider2, "easy-vpn")
+	keyId2, err := EasyVpnKeyId(mockedProvider2, "easy-vpn")
+	assert.Nil(t, err)
 	if assert.NotNil(t, keyId2) {
 		assert.Equal(t, "easy-vpn:this would be a public key!\n;)\n", keyId2)
 	}
 }
 
-func Test_SSH_ReadKeyFile(t *testing.T) {
+func Test_SSH_LoadKeyFile(t *testing.T) {
 	if assert.NotNil(t, cfg) {
 		assert.Equal(t, "vultr", cfg.Provider)
 	}
 
-	pubkey := string(readKeyFile(cfg.PublicKeyFile))
-	if assert.NotNil(t, pubkey) {
-		assert.Equal(t, "this would be a public key!\n;)\n", pubkey)
+	pubkey, err := loadKeyFile(cfg.PublicKeyFile)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "this would be a public key!\n;)\n", string(pubkey))
 	}
 
-	privkey := string(readKeyFile(cfg.PrivateKeyFile))
-	if assert.NotNil(t, privkey) {
-		assert.Equal(t, "this would be a private key!\n;)\n", privkey)
+	privkey, err := loadKeyFile(cfg.PrivateKeyFile)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "this would be a private key!\n;)\n", string(privkey))
 	}
+
+	_, err = loadKeyFile("../fixtures/does_not_exist")
+	assert.NotNil(t, err)
 }
 
 func Test_SSH_SanitizeFilename(t *testing.T) {
-	filename := sanitizeFilename("~/test/123.txt")
-	if assert.NotNil(t, filename) {
+	filename, err := sanitizeFilename("~/test/123.txt")
+	if assert.Nil(t, err) {
 		usr, _ := user.Current()
 		home := usr.HomeDir
 		assert.Equal(t, home+"/test/123.txt", filename)
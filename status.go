@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/codegangsta/cli"
+)
+
+func statusVpn(c *cli.Context) {
+	statuses, err := getEngine(c).Status(context.Background())
+	if err != nil {
+		fail(c, err)
+	}
+
+	doc := statusesDocument{Statuses: []statusDocument{}}
+	for _, status := range statuses {
+		machine := status.VM
+
+		if isStructuredOutput(c) {
+			doc.Statuses = append(doc.Statuses, newStatusDocument(status))
+			continue
+		}
+
+		fmt.Println("=========================================================================")
+		fmt.Fprintf(writer, "Id: %s\tName: %s\tIP: %s\n", machine.Id, machine.Name, machine.IP)
+		fmt.Fprintf(writer, "OS: %s\tRegion: %s\tStatus: %s\n", machine.OS, machine.Region, machine.Status)
+		writer.Flush()
+
+		if machine.Status != "active" {
+			continue
+		}
+		if status.Err != nil {
+			fmt.Printf("Could not retrieve status of virtual machine: %v\n", status.Err)
+			continue
+		}
+		printStatus(status)
+	}
+
+	if isStructuredOutput(c) {
+		printDocument(c, doc)
+		return
+	}
+	fmt.Println("=========================================================================")
+}
+
+func printStatus(status easyvpn.Status) {
+	if status.Watchdog {
+		if status.Deadline.IsZero() {
+			fmt.Fprintf(writer, "Self-destruct in: unknown\t\n")
+		} else {
+			fmt.Fprintf(writer, "Self-destruct in: %s\tDeadline: %s\n",
+				status.Remaining, status.Deadline.Local().Format(time.RFC1123))
+		}
+	} else {
+		fmt.Fprintf(writer, "Self-destruct: MISSING\tWARNING: this virtual machine will never self-destruct!\n")
+	}
+
+	vpn := "stopped"
+	if status.VpnRunning {
+		vpn = "running"
+	}
+	fmt.Fprintf(writer, "VPN: %s\tClients: %d\tLoad: %s\n", vpn, status.Clients, status.Load)
+	fmt.Fprintf(writer, "Received: %s\tSent: %s\t\n", formatBytes(status.BytesIn), formatBytes(status.BytesOut))
+	writer.Flush()
+}
+
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
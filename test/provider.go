@@ -1,6 +1,8 @@
 package test
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/JamesClonk/easy-vpn/config"
@@ -10,9 +12,10 @@ import (
 )
 
 type MockProvider struct {
-	Config *config.Config
-	Keys   []provider.SshKey
-	VMs    []provider.VM
+	Config    *config.Config
+	Keys      []provider.SshKey
+	VMs       []provider.VM
+	Firewalls []provider.Firewall
 	mock.Mock
 }
 
@@ -24,6 +27,10 @@ func (m MockProvider) GetConfig() *config.Config {
 	return m.Config
 }
 
+func (m MockProvider) WithContext(ctx context.Context) provider.API {
+	return m
+}
+
 func (m MockProvider) GetInstalledSshKeys() ([]provider.SshKey, error) {
 	return m.Keys, nil
 }
@@ -36,11 +43,24 @@ func (m MockProvider) UpdateSshKey(id, name, key string) (string, error) {
 	return id + ":" + name + ":" + key, nil
 }
 
+func (m MockProvider) DeleteSshKey(id string) error {
+	return nil
+}
+
 func (m MockProvider) GetAllVMs() ([]provider.VM, error) {
 	return m.VMs, nil
 }
 
-func (m MockProvider) CreateVM(name, os, size, region, sshkey string) (string, error) {
+func (m MockProvider) GetVM(id string) (provider.VM, error) {
+	for _, vm := range m.VMs {
+		if vm.Id == id {
+			return vm, nil
+		}
+	}
+	return provider.VM{}, errors.New("Not found")
+}
+
+func (m MockProvider) CreateVM(name, os, size, region, sshkey, userData string) (string, error) {
 	return name + ":" + os + ":" + size + ":" + region + ":" + sshkey, nil
 }
 
@@ -52,6 +72,18 @@ func (m MockProvider) DestroyVM(id string) error {
 	return nil
 }
 
+func (m MockProvider) GetFirewalls() ([]provider.Firewall, error) {
+	return m.Firewalls, nil
+}
+
+func (m MockProvider) CreateFirewall(name, vmId string, rules []provider.FirewallRule) (string, error) {
+	return name + ":" + vmId, nil
+}
+
+func (m MockProvider) DeleteFirewall(id string) error {
+	return nil
+}
+
 func (m MockProvider) Sleep() {
 	time.Sleep(5 * time.Millisecond)
 }
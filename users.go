@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/codegangsta/cli"
+)
+
+type usersDocument struct {
+	Users []userDocument `json:"users" yaml:"users"`
+}
+
+type userDocument struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	File     string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+func listUsers(c *cli.Context) {
+	users, err := getEngine(c).Users(context.Background())
+	if err != nil {
+		fail(c, err)
+	}
+
+	if isStructuredOutput(c) {
+		doc := usersDocument{Users: []userDocument{}}
+		for _, user := range users {
+			doc.Users = append(doc.Users, userDocument{Username: user.Username, Password: user.Password})
+		}
+		printDocument(c, doc)
+		return
+	}
+
+	fmt.Println("=========================================================================")
+	for _, user := range users {
+		fmt.Fprintf(writer, "Username: %s\tPassword: %s\n", user.Username, user.Password)
+	}
+	writer.Flush()
+	fmt.Println("=========================================================================")
+}
+
+func addUser(c *cli.Context) {
+	ip := prepareHandOut(c)
+	user, err := getEngine(c).AddUser(context.Background(), c.Args().First())
+	if err != nil {
+		fail(c, err)
+	}
+	handOutUser(c, user, "added", ip)
+}
+
+func rotateUser(c *cli.Context) {
+	if len(c.Args().First()) == 0 {
+		fail(c, errors.New("No username given"))
+	}
+	ip := prepareHandOut(c)
+	user, err := getEngine(c).RotateUser(context.Background(), c.Args().First())
+	if err != nil {
+		fail(c, err)
+	}
+	handOutUser(c, user, "rotated", ip)
+}
+
+func removeUser(c *cli.Context) {
+	if len(c.Args().First()) == 0 {
+		fail(c, errors.New("No username given"))
+	}
+	if err := getEngine(c).RemoveUser(context.Background(), c.Args().First()); err != nil {
+		fail(c, err)
+	}
+	fmt.Fprintf(messages(c), "VPN user [%s] removed\n", c.Args().First())
+}
+
+// prepareHandOut makes sure the credentials file of --out-dir or --encrypt-to can be written, before the user
+// is touched on the virtual machine, and returns the IP address of it to write into the file
+func prepareHandOut(c *cli.Context) string {
+	dir, recipient := userFileOptions(c)
+	if len(dir) == 0 {
+		return ""
+	}
+	machine, err := getEngine(c).Credentials(context.Background(), false)
+	if err != nil {
+		fail(c, err)
+	}
+	if err := checkUserFile(dir, recipient); err != nil {
+		fail(c, err)
+	}
+	return machine.VM.IP
+}
+
+// handOutUser prints new credentials, or writes them into a file for the user if --out-dir or --encrypt-to is given,
+// in which case the password is not printed at all, unless writing the file failed after all
+func handOutUser(c *cli.Context, user easyvpn.User, action string, ip string) {
+	doc := userDocument{Username: user.Username, Password: user.Password}
+
+	if dir, recipient := userFileOptions(c); len(dir) > 0 {
+		filename, err := writeUserFile(dir, recipient, ip, user)
+		if err != nil {
+			fmt.Fprintf(messages(c), "VPN user [%s] %s, with password [%s]\n", user.Username, action, user.Password)
+			fail(c, err)
+		}
+		doc.Password = ""
+		doc.File = filename
+	}
+
+	if isStructuredOutput(c) {
+		printDocument(c, doc)
+		return
+	}
+	if len(doc.File) > 0 {
+		fmt.Printf("VPN user [%s] %s, credentials written to [%s]\n", user.Username, action, doc.File)
+	} else {
+		fmt.Printf("VPN user [%s] %s, with password [%s]\n", user.Username, action, user.Password)
+	}
+}
+
+// userFileOptions returns the directory to write the credentials file to, empty if none is to be written
+func userFileOptions(c *cli.Context) (dir string, recipient string) {
+	dir, recipient = c.String("out-dir"), c.String("encrypt-to")
+	if len(dir) == 0 && len(recipient) > 0 {
+		dir = "."
+	}
+	return dir, recipient
+}
+
+// checkUserFile makes sure the recipient is valid and files can be written into dir
+func checkUserFile(dir, recipient string) error {
+	switch {
+	case strings.HasPrefix(recipient, "age1"):
+		if _, err := age.ParseX25519Recipient(recipient); err != nil {
+			return fmt.Errorf("Invalid age recipient: %s\n%v", recipient, err)
+		}
+	case len(recipient) > 0:
+		if _, err := encryptGpg(recipient, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Could not create directory: %s\n%v", dir, err)
+	}
+	file, err := ioutil.TempFile(dir, ".easy-vpn")
+	if err != nil {
+		return fmt.Errorf("Could not write into directory: %s\n%v", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// writeUserFile writes the connection details of a user into <dir>/<username>.txt,
+// encrypted to the given age or GPG recipient if there is one
+func writeUserFile(dir, recipient, ip string, user easyvpn.User) (string, error) {
+	data := []byte(fmt.Sprintf("server: %s\nprotocol: pptp\nport: 1723\nusername: %s\npassword: %s\n", ip, user.Username, user.Password))
+	filename := filepath.Join(dir, user.Username+".txt")
+
+	switch {
+	case strings.HasPrefix(recipient, "age1"):
+		encrypted, err := encryptAge(recipient, data)
+		if err != nil {
+			return "", err
+		}
+		data = encrypted
+		filename += ".age"
+	case len(recipient) > 0:
+		encrypted, err := encryptGpg(recipient, data)
+		if err != nil {
+			return "", err
+		}
+		data = encrypted
+		filename += ".asc"
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("Could not create directory: %s\n%v", dir, err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return "", fmt.Errorf("Could not write file: %s\n%v", filename, err)
+	}
+	return filename, nil
+}
+
+func encryptAge(recipient string, data []byte) ([]byte, error) {
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid age recipient: %s\n%v", recipient, err)
+	}
+
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not encrypt credentials\n%v", err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("Could not encrypt credentials\n%v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Could not encrypt credentials\n%v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// encryptGpg uses the gpg binary, the recipients public key has to be in the local keyring
+func encryptGpg(recipient string, data []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--batch", "--yes", "--armor", "--trust-model", "always", "--encrypt", "--recipient", recipient)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not encrypt credentials with gpg for: %s\n%s%v", recipient, stderr.String(), err)
+	}
+	return out, nil
+}
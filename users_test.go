@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Users_WriteUserFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, err := writeUserFile(dir, "", "127.0.0.1", easyvpn.User{Username: "jdoe", Password: "s3cr3t"})
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "jdoe.txt"), filename)
+
+	data, err := ioutil.ReadFile(filename)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server: 127.0.0.1\nprotocol: pptp\nport: 1723\nusername: jdoe\npassword: s3cr3t\n", string(data))
+	}
+}
+
+func Test_Users_WriteUserFile_Age(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename, err := writeUserFile(dir, identity.Recipient().String(), "127.0.0.1", easyvpn.User{Username: "jdoe", Password: "s3cr3t"})
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "jdoe.txt.age"), filename)
+
+	data, err := ioutil.ReadFile(filename)
+	if assert.Nil(t, err) {
+		assert.NotContains(t, string(data), "s3cr3t")
+
+		r, err := age.Decrypt(bytes.NewReader(data), identity)
+		if assert.Nil(t, err) {
+			plain, _ := ioutil.ReadAll(r)
+			assert.Contains(t, string(plain), "password: s3cr3t\n")
+		}
+	}
+
+	_, err = writeUserFile(dir, "age1invalid", "127.0.0.1", easyvpn.User{Username: "jdoe"})
+	assert.NotNil(t, err)
+}
+
+func Test_Users_CheckUserFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "easy-vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, checkUserFile(filepath.Join(dir, "users"), identity.Recipient().String()))
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "users"))
+	assert.Equal(t, 0, len(files))
+
+	assert.NotNil(t, checkUserFile(dir, "age1invalid"))
+
+	// a file is in the way of the directory
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600))
+	assert.NotNil(t, checkUserFile(filepath.Join(dir, "file"), ""))
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+)
+
+// delays between two polls, growing exponentially from pollInterval up to maxPollInterval
+var (
+	pollInterval    = 5 * time.Second
+	maxPollInterval = 30 * time.Second
+)
+
+// default timeouts of the polling phases, used if none are configured
+const (
+	INSTALLATION_TIMEOUT = 10 * time.Minute
+	STATUS_TIMEOUT       = 10 * time.Minute
+	READYNESS_TIMEOUT    = 15 * time.Minute
+)
+
+// TimeoutError is returned if a vm did not get past a polling phase in time
+type TimeoutError struct {
+	Phase   string // "installation", "status", "readyness" or "removal"
+	Timeout time.Duration
+	Err     error // of the last poll, if it failed
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Timed out after %v waiting for virtual machine %s\n%v", e.Timeout, e.Phase, e.Err)
+	}
+	return fmt.Sprintf("Timed out after %v waiting for virtual machine %s", e.Timeout, e.Phase)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// permanentError ends polling right away, other errors of a check only count as not done yet,
+// like a fresh vm refusing SSH connections or a hiccup of the provider API
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func timeouts(cfg *config.Config) (installation, status, readyness time.Duration) {
+	seconds := func(value int, def time.Duration) time.Duration {
+		if value <= 0 {
+			return def
+		}
+		return time.Duration(value) * time.Second
+	}
+	return seconds(cfg.Timeouts.Installation, INSTALLATION_TIMEOUT),
+		seconds(cfg.Timeouts.Status, STATUS_TIMEOUT),
+		seconds(cfg.Timeouts.Readyness, READYNESS_TIMEOUT)
+}
+
+// backoff returns the delays between polls, doubling each time up to max, with up to half of each delay being random jitter
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+	half := int64(b.current / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// poll calls check until it reports to be done, with exponential backoff in between, and gives up with a
+// TimeoutError once the timeout of the phase has passed. Errors of check are retried, unless they are permanent.
+func poll(ctx context.Context, phase string, timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	b := backoff{initial: pollInterval, max: maxPollInterval}
+	for {
+		done, err := check()
+		if perr, ok := err.(*permanentError); ok {
+			return perr.err
+		}
+		if err == nil && done {
+			return nil
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return &TimeoutError{Phase: phase, Timeout: timeout, Err: err}
+		}
+		delay := b.next()
+		if delay > remaining {
+			delay = remaining
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for the given duration, but returns early with an error if ctx is canceled
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
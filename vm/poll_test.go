@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VM_Timeouts(t *testing.T) {
+	installation, status, readyness := timeouts(&config.Config{})
+	assert.Equal(t, INSTALLATION_TIMEOUT, installation)
+	assert.Equal(t, STATUS_TIMEOUT, status)
+	assert.Equal(t, READYNESS_TIMEOUT, readyness)
+
+	if assert.NotNil(t, cfg) {
+		installation, status, readyness = timeouts(cfg)
+		assert.Equal(t, 2*time.Minute, installation)
+		assert.Equal(t, 4*time.Minute, status)
+		assert.Equal(t, READYNESS_TIMEOUT, readyness)
+	}
+}
+
+func Test_VM_Backoff(t *testing.T) {
+	b := backoff{initial: 4 * time.Second, max: 20 * time.Second}
+	for _, current := range []time.Duration{4, 8, 16, 20, 20} {
+		delay := b.next()
+		assert.True(t, delay >= current*time.Second/2, "%v too short", delay)
+		assert.True(t, delay <= current*time.Second, "%v too long", delay)
+	}
+}
+
+func Test_VM_Poll(t *testing.T) {
+	calls := 0
+	err := poll(context.Background(), "status", time.Second, func() (bool, error) {
+		calls++
+		return true, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+
+	// a failing check is only not done yet
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	calls = 0
+	err = poll(context.Background(), "readyness", time.Second, func() (bool, error) {
+		if calls++; calls < 3 {
+			return false, errors.New("connection refused")
+		}
+		return true, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	err = poll(context.Background(), "readyness", 20*time.Millisecond, func() (bool, error) {
+		return false, errors.New("connection refused")
+	})
+	if assert.IsType(t, &TimeoutError{}, err) {
+		assert.Equal(t, "Timed out after 20ms waiting for virtual machine readyness\nconnection refused", err.Error())
+	}
+
+	calls = 0
+	err = poll(context.Background(), "status", time.Second, func() (bool, error) {
+		calls++
+		return false, &permanentError{errors.New("mock error")}
+	})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "mock error", err.Error())
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func Test_VM_Poll_Timeout(t *testing.T) {
+	err := poll(context.Background(), "installation", 20*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if assert.IsType(t, &TimeoutError{}, err) {
+		assert.Equal(t, "installation", err.(*TimeoutError).Phase)
+		assert.Equal(t, "Timed out after 20ms waiting for virtual machine installation", err.Error())
+	}
+}
+
+func Test_VM_Poll_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := poll(ctx, "readyness", time.Minute, func() (bool, error) {
+		return false, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
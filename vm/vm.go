@@ -1,182 +1,249 @@
 package vm
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/JamesClonk/easy-vpn/provider"
 	"github.com/JamesClonk/easy-vpn/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
-func GetAll(p provider.API) []provider.VM {
+// Progress describes where status messages of long running vm operations are written to
+type Progress struct {
+	Writer io.Writer
+	Quiet  bool // no progress dots and no command output, used when provisioning several vm's at once
+}
+
+var Stdout = Progress{Writer: os.Stdout}
+
+func (p Progress) Printf(format string, a ...interface{}) {
+	if p.Writer != nil {
+		fmt.Fprintf(p.Writer, format, a...)
+	}
+}
+
+func (p Progress) Println(a ...interface{}) {
+	if p.Writer != nil {
+		fmt.Fprintln(p.Writer, a...)
+	}
+}
+
+func GetAll(p provider.API) ([]provider.VM, error) {
 	machines, err := p.GetAllVMs()
 	if err != nil {
-		log.Println("Could not retrieve list of virtual machines")
-		log.Fatal(err)
+		return nil, fmt.Errorf("Could not retrieve list of virtual machines: %v", err)
+	}
+	return machines, nil
+}
+
+// Find looks up a vm by its name, returning false if it does not exist
+func Find(p provider.API, vmName string) (provider.VM, bool, error) {
+	machines, err := GetAll(p)
+	if err != nil {
+		return provider.VM{}, false, err
 	}
-	return machines
+	for _, machine := range machines {
+		if machine.Name == vmName {
+			return machine, true, nil
+		}
+	}
+	return provider.VM{}, false, nil
 }
 
-func GetEasyVpn(p provider.API, sshkeyId string, vmName string) (vm provider.VM) {
+// Get returns the named vm, creating it first if it does not exist yet, and waits until it is ready to be used.
+// created reports whether a new vm was created, so callers know what to clean up if anything fails later on.
+// A newly created vm that gets stuck is replaced by a new one, as often as configured in the timeouts retries.
+func Get(ctx context.Context, p provider.API, sshkeyId string, vmName string, progress Progress) (vm provider.VM, created bool, err error) {
+	p = p.WithContext(ctx)
+	retries := p.GetConfig().Timeouts.Retries
+
+	for attempt := 0; ; attempt++ {
+		vm, created, err = get(ctx, p, sshkeyId, vmName, progress)
+		if _, timedOut := err.(*TimeoutError); !timedOut || !created || attempt >= retries {
+			return vm, created, err
+		}
+
+		progress.Printf("\n%v, destroy it and try again with a new one\n", err)
+		if err := replace(ctx, p, vm, progress); err != nil {
+			return vm, created, err
+		}
+	}
+}
+
+func get(ctx context.Context, p provider.API, sshkeyId string, vmName string, progress Progress) (vm provider.VM, created bool, err error) {
 	cfg := p.GetConfig()
 	os := cfg.Providers[cfg.Provider].OS
 	size := cfg.Providers[cfg.Provider].Size
 	region := cfg.Providers[cfg.Provider].Region
 
 	// check to see if easy-vpn vm already exists
-	vmExists := false
-	for _, machine := range GetAll(p) {
-		if machine.Name == vmName {
-			vm = machine
-			vmExists = true
-			break
-		}
+	var hostKey gossh.PublicKey
+	vm, vmExists, err := Find(p, vmName)
+	if err != nil {
+		return vm, false, err
 	}
 
 	if vmExists {
-		fmt.Println("Virtual machine already exists")
+		progress.Println("Virtual machine already exists")
 	} else { // create a new vm and start it if it did not yet exist
-		fmt.Println("Create new virtual machine")
+		progress.Println("Create new virtual machine")
+
+		// the host key is generated here and handed to the vm, so not even the first SSH connection has to be trusted blindly
+		userData, key, err := ssh.NewHostKey()
+		if err != nil {
+			return vm, false, err
+		}
+		hostKey = key
 
-		_, err := p.CreateVM(vmName, os, size, region, sshkeyId)
+		id, err := p.CreateVM(vmName, os, size, region, sshkeyId, userData)
 		if err != nil {
-			log.Println("Could not create new virtual machine")
-			log.Fatal(err)
+			return vm, false, fmt.Errorf("Could not create new virtual machine: %v", err)
+		}
+		created = true
+		vm.Id = id
+		vm.Name = vmName
+
+		if err := waitForNewVM(ctx, p, &vm, progress); err != nil {
+			return vm, created, err
 		}
-		waitForNewVM(p, &vm, vmName)
 	}
 
 	// make sure its up and running
-	statusOfVM(p, &vm)
+	if err := statusOfVM(ctx, p, &vm, progress); err != nil {
+		return vm, created, err
+	}
+	if hostKey != nil {
+		if err := ssh.Trust(cfg, vm.IP, hostKey); err != nil {
+			return vm, created, err
+		}
+	}
 
 	// wait a few seconds in between status and readyness check if this was a newly created vm
 	// to allow sshd to be ready for accepting connections
-	if !vmExists {
-		time.Sleep(10 * time.Second)
+	if created {
+		if err := sleep(ctx, 10*time.Second); err != nil {
+			return vm, created, err
+		}
 	}
 
 	// this is needed because some providers such as vultr do a dist-upgrade on new vms
-	readynessOfVM(p, &vm)
+	if err := readynessOfVM(ctx, p, &vm, progress); err != nil {
+		return vm, created, err
+	}
 
-	fmt.Println()
+	progress.Println()
 
-	return
+	return vm, created, nil
 }
 
-func DestroyEasyVpn(p provider.API, vmName string) {
-	var vm provider.VM
-
-	// check to see if easy-vpn vm actually exists
-	vmExists := false
-	for _, machine := range GetAll(p) {
-		if machine.Name == vmName {
-			vm = machine
-			vmExists = true
-			break
+// replace destroys a stuck vm and waits until it is gone, so that a new one of the same name can be created
+func replace(ctx context.Context, p provider.API, vm provider.VM, progress Progress) error {
+	if err := p.DestroyVM(vm.Id); err != nil {
+		return fmt.Errorf("Could not destroy stuck virtual machine: %v", err)
+	}
+	if len(vm.IP) > 0 {
+		// the new vm pins its own host key anyway, even if it gets the same IP address
+		if err := ssh.Forget(p.GetConfig(), vm.IP); err != nil {
+			progress.Println(err)
 		}
 	}
 
-	// ask to destroy it if it exists
-	if vmExists {
-		fmt.Println("Do you really want to destroy the following virtual machine?")
-		fmt.Printf("%q\n", vm)
-		fmt.Printf(`Confirm with "YES": `)
+	installation, _, _ := timeouts(p.GetConfig())
+	return poll(ctx, "removal", installation, func() (bool, error) {
+		_, exists, err := Find(p, vm.Name)
+		return !exists, err
+	})
+}
+
+func waitForNewVM(ctx context.Context, p provider.API, vm *provider.VM, progress Progress) error {
+	progress.Printf("Virtual machine installation")
+	ticker := ticker(progress)
+	defer ticker.Stop()
 
-		reader := bufio.NewReader(os.Stdin)
-		answer, err := reader.ReadString('\n')
+	timeout, _, _ := timeouts(p.GetConfig())
+	if err := poll(ctx, "installation", timeout, func() (bool, error) {
+		machine, err := p.GetVM(vm.Id)
 		if err != nil {
-			log.Fatal(err)
+			return false, fmt.Errorf("Could not retrieve virtual machine: %v", err)
 		}
-		answer = strings.Trim(answer, "\t\n\r ")
-
-		if answer == "YES" {
-			fmt.Println("Destroy virtual machine")
-			err := p.DestroyVM(vm.Id)
-			if err != nil {
-				log.Println("Could not destroy virtual machine")
-				log.Fatal(err)
-			}
+		if len(machine.Id) == 0 {
+			return false, nil
 		}
-	} else {
-		fmt.Println("Virtual machine did not exist")
+		*vm = machine
+		return true, nil
+	}); err != nil {
+		return err
 	}
 
+	ticker.Stop()
+	progress.Printf("\nVirtual machine created: %q\n", vm) // TODO: prettify
+	return nil
 }
 
-func waitForNewVM(p provider.API, vm *provider.VM, vmName string) {
-	fmt.Printf("Virtual machine installation")
-	ticker := ticker()
-
-	// TODO: maybe have some maximum waiting/polling time for doing a timeout
-POLL:
-	for {
-		for _, machine := range GetAll(p) {
-			if machine.Name == vmName {
-				vm.Id = machine.Id
-				vm.Name = machine.Name
-				vm.Status = machine.Status
-				vm.IP = machine.IP
-				vm.OS = machine.OS
-				vm.Region = machine.Region
-
-				ticker.Stop()
-				break POLL
-			}
-		}
-		time.Sleep(15 * time.Second)
-	}
-	fmt.Printf("\nVirtual machine created: %q\n", vm) // TODO: prettify
-}
+func statusOfVM(ctx context.Context, p provider.API, vm *provider.VM, progress Progress) error {
+	progress.Printf("Virtual machine status check")
+	ticker := ticker(progress)
+	defer ticker.Stop()
 
-func statusOfVM(p provider.API, vm *provider.VM) {
-	fmt.Printf("Virtual machine status check")
-	ticker := ticker()
-
-	// TODO: maybe have some maximum waiting/polling time for doing a timeout
-POLL:
-	for {
-		for _, machine := range GetAll(p) {
-			if machine.Id == vm.Id &&
-				machine.Status == "active" {
-				vm.Status = machine.Status
-				vm.IP = machine.IP
-
-				ticker.Stop()
-				break POLL
-			}
+	_, timeout, _ := timeouts(p.GetConfig())
+	if err := poll(ctx, "status", timeout, func() (bool, error) {
+		machine, err := p.GetVM(vm.Id)
+		if err != nil {
+			return false, fmt.Errorf("Could not retrieve virtual machine: %v", err)
 		}
-		time.Sleep(10 * time.Second)
+		if machine.Status != "active" || len(machine.IP) == 0 {
+			return false, nil
+		}
+		vm.Status = machine.Status
+		vm.IP = machine.IP
+		return true, nil
+	}); err != nil {
+		return err
 	}
-	fmt.Printf("\nVirtual machine is active\n")
+
+	ticker.Stop()
+	progress.Printf("\nVirtual machine is active\n")
+	return nil
 }
 
-func readynessOfVM(p provider.API, vm *provider.VM) {
-	fmt.Printf("Virtual machine readyness check")
-	ticker := ticker()
+func readynessOfVM(ctx context.Context, p provider.API, vm *provider.VM, progress Progress) error {
+	progress.Printf("Virtual machine readyness check")
+	ticker := ticker(progress)
+	defer ticker.Stop()
 
-	// TODO: maybe have some maximum waiting/polling time for doing a timeout
-POLL:
-	for {
+	_, _, timeout := timeouts(p.GetConfig())
+	if err := poll(ctx, "readyness", timeout, func() (bool, error) {
 		// TODO: improve apt-get lock check
-		out := ssh.Exec(p, vm.IP, `lsof /var/lib/dpkg/lock >/dev/null 2>&1; [ $? = 0 ] && echo "locked"; echo "..."`)
-		if !strings.Contains(out, "locked") {
-			ticker.Stop()
-			break POLL
+		out, err := ssh.Run(ctx, p, *vm, `lsof /var/lib/dpkg/lock >/dev/null 2>&1; [ $? = 0 ] && echo "locked"; echo "..."`)
+		if _, ok := err.(*ssh.HostKeyError); ok {
+			return false, &permanentError{err} // waiting does not make it any better
+		}
+		if err != nil {
+			return false, fmt.Errorf("Could not check readyness of virtual machine: %v", err)
 		}
-		time.Sleep(15 * time.Second)
+		return !strings.Contains(out, "locked"), nil
+	}); err != nil {
+		return err
 	}
-	fmt.Printf("\nVirtual machine is ready\n")
+
+	ticker.Stop()
+	progress.Printf("\nVirtual machine is ready\n")
+	return nil
 }
 
-func ticker() *time.Ticker {
+func ticker(progress Progress) *time.Ticker {
 	ticker := time.NewTicker(1 * time.Second)
+	if progress.Quiet {
+		return ticker
+	}
 	go func() {
 		for range ticker.C {
-			fmt.Print(".")
+			progress.Printf(".")
 		}
 	}()
 	return ticker
@@ -1,8 +1,11 @@
 package vm
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/JamesClonk/easy-vpn/config"
 	"github.com/JamesClonk/easy-vpn/provider"
@@ -35,25 +38,39 @@ func Test_VM_GetAll(t *testing.T) {
 		},
 	}
 
-	machines1 := GetAll(mockedProvider1)
+	machines1, err := GetAll(mockedProvider1)
+	assert.Nil(t, err)
 	if assert.NotNil(t, machines1) {
 		assert.Equal(t, 3, len(machines1))
 	}
 
 	mockedProvider2 := test.MockProvider{Config: cfg}
-	machines2 := GetAll(mockedProvider2)
+	machines2, err := GetAll(mockedProvider2)
+	assert.Nil(t, err)
 	assert.Nil(t, machines2)
 }
 
-func Test_VM_DestroyEasyVpn_NonExisting(t *testing.T) {
+func Test_VM_Find(t *testing.T) {
 	mockedProvider := test.MockProvider{
 		Config: cfg,
 		VMs: []provider.VM{
 			provider.VM{},
+			provider.VM{
+				Name: "easy-vpn",
+				Id:   "mockId",
+			},
 		},
 	}
 
-	DestroyEasyVpn(mockedProvider, "does not exist")
+	vm, exists, err := Find(mockedProvider, "easy-vpn")
+	assert.Nil(t, err)
+	if assert.True(t, exists) {
+		assert.Equal(t, "mockId", vm.Id)
+	}
+
+	_, exists, err = Find(mockedProvider, "does not exist")
+	assert.Nil(t, err)
+	assert.False(t, exists)
 }
 
 func Test_VM_WaitForNewVM(t *testing.T) {
@@ -70,10 +87,26 @@ func Test_VM_WaitForNewVM(t *testing.T) {
 		},
 	}
 
-	var vm provider.VM
-	waitForNewVM(mockedProvider, &vm, "easy-vpn")
+	vm := provider.VM{
+		Id: "mockId",
+	}
+	assert.Nil(t, waitForNewVM(context.Background(), mockedProvider, &vm, Stdout))
 	if assert.NotNil(t, vm) {
-		assert.Equal(t, "mockId", vm.Id)
+		assert.Equal(t, "easy-vpn", vm.Name)
+	}
+
+	// the provider failing to answer is retried until the timeout
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+	shortCfg := *cfg
+	shortCfg.Timeouts.Installation = 1
+	mockedProvider.Config = &shortCfg
+	vm = provider.VM{
+		Id: "does not exist",
+	}
+	err := waitForNewVM(context.Background(), mockedProvider, &vm, Progress{Writer: ioutil.Discard, Quiet: true})
+	if assert.IsType(t, &TimeoutError{}, err) {
+		assert.Contains(t, err.Error(), "Could not retrieve virtual machine: Not found")
 	}
 }
 
@@ -85,6 +118,7 @@ func Test_VM_StatusOfVM(t *testing.T) {
 				Name:   "easy-vpn",
 				Id:     "mockId",
 				Status: "active",
+				IP:     "127.0.0.1",
 			},
 		},
 	}
@@ -93,8 +127,9 @@ func Test_VM_StatusOfVM(t *testing.T) {
 		Id: "mockId",
 	}
 
-	statusOfVM(mockedProvider, &vm)
+	assert.Nil(t, statusOfVM(context.Background(), mockedProvider, &vm, Progress{Writer: ioutil.Discard, Quiet: true}))
 	if assert.NotNil(t, vm) {
 		assert.Equal(t, "active", vm.Status)
+		assert.Equal(t, "127.0.0.1", vm.IP)
 	}
 }
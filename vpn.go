@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/JamesClonk/easy-vpn/client"
+	"github.com/JamesClonk/easy-vpn/config"
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/codegangsta/cli"
+)
+
+func connectVpn(c *cli.Context) {
+	e := getEngine(c)
+	deployment, err := e.Credentials(context.Background(), false)
+	if err != nil {
+		fail(c, err)
+	}
+	connectTo(c, deployment, c.String("connector"))
+
+	if c.Bool("watch") {
+		watch(c, e, deployment)
+	}
+}
+
+// connectTo connects this machine to the VPN server of a deployment, through the given or the configured connector
+func connectTo(c *cli.Context, deployment *easyvpn.Deployment, name string) {
+	if _, err := connect(c, deployment, name); err != nil {
+		fail(c, err)
+	}
+}
+
+func connect(c *cli.Context, deployment *easyvpn.Deployment, name string) (*client.Session, error) {
+	cfg := parseGlobalOptions(c)
+
+	connector, endpoint, err := endpointOf(c, cfg, deployment, name)
+	if err != nil {
+		return nil, err
+	}
+	killSwitch := cfg.Options.KillSwitch || c.Bool("kill-switch")
+	if killSwitch && endpoint.Routing.Split() {
+		return nil, fmt.Errorf("The kill switch can not be used together with split tunneling, only with excluded networks")
+	}
+	// remember the public IP address from before, to tell afterwards whether traffic goes through the VPN
+	verify := !c.Bool("skip-verify")
+	var before string
+	if verify && !endpoint.Routing.Split() {
+		if before, _, err = client.EgressIP(cfg.Options.VerifyURL); err != nil {
+			fmt.Fprintf(messages(c), "Warning: %v\n", err)
+		}
+	}
+
+	session, err := client.Connect(connector, endpoint, cfg.GetStateDir())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(messages(c), "Connected to VPN [%s] through [%s]\n", session.Server, session.Connector)
+
+	if verify {
+		if err := verifyConnection(c, cfg, session, before); err != nil {
+			return nil, err
+		}
+	}
+
+	if killSwitch {
+		if err := client.EnableKillSwitch(session, cfg.GetStateDir()); err != nil {
+			return session, err
+		}
+		fmt.Fprintln(messages(c), "Kill switch enabled, all traffic outside the VPN is dropped until disconnect")
+	}
+	return session, nil
+}
+
+// endpointOf returns the connector to use and everything it needs to know about the VPN server of a deployment
+func endpointOf(c *cli.Context, cfg *config.Config, deployment *easyvpn.Deployment, name string) (client.Connector, client.Endpoint, error) {
+	connector, err := client.Get(name, deployment.Protocol, cfg.Options, messages(c))
+	if err != nil {
+		return nil, client.Endpoint{}, err
+	}
+	// domains are resolved before connecting, through the nameservers from before
+	routing, err := client.ResolveRouting(cfg.Routing)
+	if err != nil {
+		return nil, client.Endpoint{}, err
+	}
+
+	return connector, client.Endpoint{
+		Protocol: deployment.Protocol,
+		IP:       deployment.VM.IP,
+		Port:     deployment.Port,
+		Username: deployment.Username,
+		Password: deployment.Password,
+		Routing:  routing,
+	}, nil
+}
+
+// verifyConnection disconnects again if traffic does not actually go through the VPN
+func verifyConnection(c *cli.Context, cfg *config.Config, session *client.Session, before string) error {
+	v, err := client.Verify(session, cfg.Options.VerifyURL, before)
+	if err != nil {
+		if _, derr := client.Disconnect(cfg.GetStateDir(), cfg.Options, messages(c)); derr != nil {
+			fmt.Fprintf(messages(c), "Could not disconnect again\n%v\n", derr)
+		}
+		return err
+	}
+
+	if len(session.Included) > 0 {
+		fmt.Fprintf(messages(c), "Verified VPN: [%s] routed through [%s]\n", strings.Join(session.Included, ", "), v.Interface)
+		return nil
+	}
+	fmt.Fprintf(messages(c), "Verified VPN: public IP [%s] -> [%s], latency %v", v.EgressBefore, v.EgressAfter, v.Latency)
+	if len(v.Interface) > 0 {
+		fmt.Fprintf(messages(c), ", routed through [%s], nameservers [%s]", v.Interface, strings.Join(v.Nameservers, ", "))
+	}
+	fmt.Fprintln(messages(c))
+	return nil
+}
+
+func disconnectVpn(c *cli.Context) {
+	cfg := parseGlobalOptions(c)
+
+	session, err := client.Disconnect(cfg.GetStateDir(), cfg.Options, messages(c))
+	if err != nil {
+		fail(c, err)
+	}
+	fmt.Fprintf(messages(c), "Disconnected from VPN [%s]\n", session.Server)
+}
+
+func recoverNetwork(c *cli.Context) {
+	cfg := parseGlobalOptions(c)
+
+	session, err := client.Recover(cfg.GetStateDir(), cfg.Options, messages(c))
+	if err != nil {
+		fail(c, err)
+	}
+	if session != nil {
+		fmt.Fprintf(messages(c), "Recovered from VPN connection [%s]\n", session.Server)
+	} else {
+		fmt.Fprintln(messages(c), "No VPN connection to recover from, removed any leftover kill switch rules")
+	}
+}
+
+// disconnectFrom disconnects this machine from the VPN if it is connected to the given server,
+// so that destroying the server does not leave it without working routes
+func disconnectFrom(c *cli.Context, ip string) error {
+	cfg := parseGlobalOptions(c)
+
+	session, err := client.Current(cfg.GetStateDir())
+	if err != nil || session == nil || session.Server != ip {
+		return err
+	}
+	fmt.Fprintf(messages(c), "Disconnect from VPN [%s]\n", session.Server)
+	_, err = client.Disconnect(cfg.GetStateDir(), cfg.Options, messages(c))
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/JamesClonk/easy-vpn/client"
+	"github.com/JamesClonk/easy-vpn/easyvpn"
+	"github.com/JamesClonk/easy-vpn/vm"
+	"github.com/codegangsta/cli"
+)
+
+// delays between reconnect attempts, doubling up to maxReconnectDelay
+const (
+	reconnectDelay    = 5 * time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// after this many failed reconnects the virtual machine is checked for having vanished
+const reconnectAttempts = 3
+
+// watch monitors the VPN connection in the foreground until interrupted, reconnecting whenever it breaks down
+// and re-creating the virtual machine if it is gone, e.g. after it self-destructed
+func watch(c *cli.Context, e *easyvpn.Engine, deployment *easyvpn.Deployment) {
+	cfg := parseGlobalOptions(c)
+	out := messages(c)
+	e.Progress = vm.Progress{Writer: out}
+
+	ctx, cancel := interruptible(c)
+	defer cancel()
+
+	interval := time.Duration(c.Int("interval")) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	fmt.Fprintf(out, "Watching VPN connection every %v, press Ctrl-C to disconnect\n", interval)
+
+	failures := 0
+	delay := reconnectDelay
+	for {
+		select {
+		case <-ctx.Done():
+			if _, err := client.Disconnect(cfg.GetStateDir(), cfg.Options, out); err != nil && err != client.ErrNotConnected {
+				fail(c, err)
+			}
+			fmt.Fprintf(out, "Disconnected from VPN [%s]\n", deployment.VM.IP)
+			return
+		case <-time.After(interval):
+		}
+
+		session, err := client.Current(cfg.GetStateDir())
+		if err != nil {
+			fail(c, err)
+		}
+		if session != nil {
+			if err = client.Healthy(session); err == nil {
+				failures, delay = 0, reconnectDelay
+				continue
+			}
+		} else {
+			err = client.ErrNotConnected
+		}
+		failures++
+		fmt.Fprintf(out, "%s VPN connection broke down: %v\n", time.Now().Format(time.Stamp), err)
+
+		if failures > reconnectAttempts {
+			deployment, err = recreate(ctx, c, e, deployment)
+		} else {
+			err = reconnect(c, deployment)
+		}
+		if err == nil {
+			fmt.Fprintf(out, "%s Reconnected to VPN [%s]\n", time.Now().Format(time.Stamp), deployment.VM.IP)
+			failures, delay = 0, reconnectDelay
+			continue
+		}
+		fmt.Fprintf(out, "Could not reconnect, trying again in %v: %v\n", delay, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// reconnect runs the connector again, keeping any kill switch in place
+func reconnect(c *cli.Context, deployment *easyvpn.Deployment) error {
+	cfg := parseGlobalOptions(c)
+
+	session, err := client.Current(cfg.GetStateDir())
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		_, err = connect(c, deployment, c.String("connector"))
+		return err
+	}
+
+	connector, endpoint, err := endpointOf(c, cfg, deployment, session.Connector)
+	if err != nil {
+		return err
+	}
+	_, err = client.Reconnect(connector, endpoint, cfg.GetStateDir(), cfg.Options, messages(c))
+	return err
+}
+
+// recreate checks whether the virtual machine has vanished, and if so brings up a new one.
+// The VPN connection has to be torn down for that, including any kill switch, to reach the VPS provider.
+// With the kill switch enabled that only happens with --yes, otherwise it keeps on reconnecting.
+func recreate(ctx context.Context, c *cli.Context, e *easyvpn.Engine, deployment *easyvpn.Deployment) (*easyvpn.Deployment, error) {
+	cfg := parseGlobalOptions(c)
+	out := messages(c)
+
+	session, err := client.Current(cfg.GetStateDir())
+	if err != nil {
+		return deployment, err
+	}
+	if session != nil && len(session.KillSwitch) > 0 && !c.Bool("yes") {
+		fmt.Fprintln(out, "Not checking on the virtual machine, that would drop the kill switch, use --yes to allow it")
+		return deployment, reconnect(c, deployment)
+	}
+
+	fmt.Fprintln(out, "Disconnect from VPN to check on the virtual machine, traffic is no longer protected until reconnected")
+	if _, err := client.Disconnect(cfg.GetStateDir(), cfg.Options, out); err != nil && err != client.ErrNotConnected {
+		return deployment, err
+	}
+
+	current, err := e.Credentials(ctx, false)
+	if err == easyvpn.ErrNotFound {
+		fmt.Fprintf(out, "Virtual machine [%s] is gone, create a new one\n", deployment.VM.Name)
+		e.AdminIPs = adminIPs(c)
+		current, err = e.Up(ctx)
+		if perr, ok := err.(*easyvpn.ProvisionError); ok && perr.Created {
+			rollback(c, e, perr.VM, ctx.Err() != nil)
+		}
+	}
+	if err != nil {
+		return deployment, err
+	}
+
+	if _, err := connect(c, current, c.String("connector")); err != nil {
+		return current, err
+	}
+	return current, nil
+}